@@ -0,0 +1,148 @@
+package raweml
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SetMarkdownBody sets the TextBody to the markdown and the HTMLBody to the markdown rendered as HTML
+// so the email is sent as multipart/alternative.
+// Supported markdown: headings, paragraphs, bold, italic, inline code, links, images, lists, blockquotes, code blocks and horizontal rules.
+func (email *Email) SetMarkdownBody(md string) {
+	email.TextBody = md
+	email.HTMLBody = renderMarkdown(md)
+}
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdUnordered   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered     = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdRule        = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdCode        = regexp.MustCompile("`([^`]+)`")
+	mdImage       = regexp.MustCompile(`!\[([^\]]*)\]\(((?:[^()\s]|\([^()\s]*\))+)\)`)
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(((?:[^()\s]|\([^()\s]*\))+)\)`)
+	mdBold        = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	mdItalic      = regexp.MustCompile(`(^|[^*\w])[*_]([^*_]+)[*_]`)
+	mdPlaceholder = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// renderMarkdown converts the markdown to HTML
+func renderMarkdown(md string) string {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	b := new(strings.Builder)
+	var paragraph []string
+	list := "" // "ul" or "ol" while rendering a list
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + renderMarkdownInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	closeList := func() {
+		if len(list) > 0 {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "```"):
+			flushParagraph()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case len(strings.TrimSpace(line)) == 0:
+			flushParagraph()
+			closeList()
+		case mdHeading.MatchString(line):
+			flushParagraph()
+			closeList()
+			m := mdHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+		case mdRule.MatchString(line):
+			flushParagraph()
+			closeList()
+			b.WriteString("<hr />\n")
+		case mdUnordered.MatchString(line):
+			flushParagraph()
+			openList("ul")
+			b.WriteString("<li>" + renderMarkdownInline(mdUnordered.FindStringSubmatch(line)[1]) + "</li>\n")
+		case mdOrdered.MatchString(line):
+			flushParagraph()
+			openList("ol")
+			b.WriteString("<li>" + renderMarkdownInline(mdOrdered.FindStringSubmatch(line)[1]) + "</li>\n")
+		case strings.HasPrefix(strings.TrimSpace(line), ">"):
+			flushParagraph()
+			closeList()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			b.WriteString("<blockquote>\n" + renderMarkdown(strings.Join(quote, "\n")) + "</blockquote>\n")
+		default:
+			closeList()
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	flushParagraph()
+	closeList()
+	return b.String()
+}
+
+// renderMarkdownInline converts the inline markdown (code, images, links, bold and italic) to HTML.
+// The link and image URLs can contain one level of balanced parentheses (e.g. "https://en.wikipedia.org/wiki/Go_(language)").
+func renderMarkdownInline(s string) string {
+	// code spans and URLs are replaced with placeholders so they are not formatted
+	var protected []string
+	protect := func(v string) string {
+		protected = append(protected, v)
+		return "\x00" + strconv.Itoa(len(protected)-1) + "\x00"
+	}
+	s = strings.ReplaceAll(s, "\x00", "")
+	s = mdCode.ReplaceAllStringFunc(s, func(m string) string {
+		return protect("<code>" + html.EscapeString(mdCode.FindStringSubmatch(m)[1]) + "</code>")
+	})
+	s = mdImage.ReplaceAllStringFunc(s, func(m string) string {
+		sm := mdImage.FindStringSubmatch(m)
+		return protect("<img" + markdownURLAttribute("src", sm[2]) + ` alt="` + html.EscapeString(sm[1]) + `" />`)
+	})
+	s = mdLink.ReplaceAllStringFunc(s, func(m string) string {
+		sm := mdLink.FindStringSubmatch(m)
+		return protect("<a"+markdownURLAttribute("href", sm[2])+">") + sm[1] + protect("</a>")
+	})
+
+	s = html.EscapeString(s)
+	s = mdBold.ReplaceAllString(s, "<strong>$2</strong>")
+	s = mdItalic.ReplaceAllString(s, "$1<em>$2</em>")
+
+	return mdPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+		n, _ := strconv.Atoi(mdPlaceholder.FindStringSubmatch(m)[1])
+		return protected[n]
+	})
+}
+
+// markdownURLAttribute returns the URL attribute (with the leading space) or blank string when the URL scheme is not allowed
+// (only the relative URLs and the allowedURLSchemes are kept)
+func markdownURLAttribute(name, url string) string {
+	if !isSafeURL(normalizeAttribute(url)) {
+		return ""
+	}
+	return " " + name + `="` + html.EscapeString(url) + `"`
+}
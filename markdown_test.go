@@ -0,0 +1,59 @@
+package raweml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	t.Run("Test rendering markdown", func(t *testing.T) {
+		tests := []struct {
+			md   string
+			want string
+		}{
+			{"# Hello", "<h1>Hello</h1>\n"},
+			{"### Hello ###", "<h3>Hello</h3>\n"},
+			{"Hello **bold** and *italic* `a<b`", "<p>Hello <strong>bold</strong> and <em>italic</em> <code>a&lt;b</code></p>\n"},
+			{"See [docs](https://example.com/?a=1&b=2)", `<p>See <a href="https://example.com/?a=1&amp;b=2">docs</a></p>` + "\n"},
+			{"- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+			{"1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+			{"```\n<b>code</b>\n```", "<pre><code>&lt;b&gt;code&lt;/b&gt;</code></pre>\n"},
+			{"> quote", "<blockquote>\n<p>quote</p>\n</blockquote>\n"},
+			{"snake_case_name <script>", "<p>snake_case_name &lt;script&gt;</p>\n"},
+			{"---", "<hr />\n"},
+			{"[x](javascript:alert(1))", "<p><a>x</a></p>\n"},
+			{"[x](JavaScript:alert(1)) ![y](data:text/html,x)", `<p><a>x</a> <img alt="y" /></p>` + "\n"},
+			{"[mail](mailto:a@example.com) ![logo](cid:logo) [rel](/docs)", `<p><a href="mailto:a@example.com">mail</a> <img src="cid:logo" alt="logo" /> <a href="/docs">rel</a></p>` + "\n"},
+			{"[Go](https://en.wikipedia.org/wiki/Go_(language)) (see)", `<p><a href="https://en.wikipedia.org/wiki/Go_(language)">Go</a> (see)</p>` + "\n"},
+		}
+		for _, tc := range tests {
+			if got := renderMarkdown(tc.md); got != tc.want {
+				t.Errorf("Invalid HTML for %q!\nwant:%s\ngot:%s", tc.md, tc.want, got)
+			}
+		}
+	})
+	t.Run("Test SetMarkdownBody", func(t *testing.T) {
+		md := "# Welcome\n\nRead the [guide](https://example.com/guide)."
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Markdown",
+		}
+		eml.SetMarkdownBody(md)
+		if eml.TextBody != md {
+			t.Errorf("Text body should be the raw markdown!\nwant:%s\ngot:%s", md, eml.TextBody)
+		}
+		for _, want := range []string{"<h1>Welcome</h1>", `<a href="https://example.com/guide">guide</a>`} {
+			if !strings.Contains(eml.HTMLBody, want) {
+				t.Errorf("Missing rendered HTML!\nwant:%s\ngot:%s", want, eml.HTMLBody)
+			}
+		}
+		tree, err := eml.MIMETree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.ContentType != "multipart/alternative" || len(tree.Parts) != 2 {
+			t.Errorf("Expected multipart/alternative with two parts!\ngot:%s (%d parts)", tree.ContentType, len(tree.Parts))
+		}
+	})
+}
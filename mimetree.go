@@ -0,0 +1,69 @@
+package raweml
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// MIMEPart represents a part of the composed email (see Email.MIMETree())
+type MIMEPart struct {
+	ContentType string               // media type without parameters (e.g. "multipart/mixed", "text/plain")
+	Header      textproto.MIMEHeader // part header
+	Parts       []*MIMEPart          // child parts (multipart only)
+	BodyLength  int                  // length of the encoded body (not set for multipart)
+}
+
+// MIMETree composes the email and returns its MIME structure (e.g. to verify the mixed/alternative nesting).
+// The email is composed on a clone so its Headers are not changed.
+// It returns ErrNonSeekableReader if the email has non-seekable readers that are not prepared (see Prepare).
+func (email Email) MIMETree() (*MIMEPart, error) {
+	if err := email.checkOneShot(); err != nil {
+		return nil, err
+	}
+	data, err := email.Clone().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return parseMIMEPart(textproto.MIMEHeader(msg.Header), msg.Body)
+}
+
+// parseMIMEPart reads the part body and its child parts recursively
+func parseMIMEPart(header textproto.MIMEHeader, body io.Reader) (*MIMEPart, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	part := &MIMEPart{ContentType: mediaType, Header: header}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		n, err := io.Copy(io.Discard, body)
+		part.BodyLength = int(n)
+		return part, err
+	}
+
+	r := multipart.NewReader(bufio.NewReader(body), params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		child, err := parseMIMEPart(p.Header, p)
+		if err != nil {
+			return nil, err
+		}
+		part.Parts = append(part.Parts, child)
+	}
+	return part, nil
+}
@@ -0,0 +1,73 @@
+package raweml
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMIMETree(t *testing.T) {
+	t.Run("Test MIME tree of email with text, HTML and attachment", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "MIME tree",
+			TextBody:    "Hello",
+			HTMLBody:    "<h1>Hello</h1>",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png", ContentType: "image/png"}},
+		}
+		root, err := eml.MIMETree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root.ContentType != "multipart/mixed" || len(root.Parts) != 2 {
+			t.Fatalf("Invalid root part!\nwant:%s with 2 parts\ngot:%s with %v parts", "multipart/mixed", root.ContentType, len(root.Parts))
+		}
+		alt := root.Parts[0]
+		if alt.ContentType != "multipart/alternative" || len(alt.Parts) != 2 {
+			t.Fatalf("Invalid alternative part!\nwant:%s with 2 parts\ngot:%s with %v parts", "multipart/alternative", alt.ContentType, len(alt.Parts))
+		}
+		if alt.Parts[0].ContentType != "text/plain" || alt.Parts[1].ContentType != "text/html" {
+			t.Errorf("Invalid body parts!\nwant:%s, %s\ngot:%s, %s", "text/plain", "text/html", alt.Parts[0].ContentType, alt.Parts[1].ContentType)
+		}
+		if att := root.Parts[1]; att.ContentType != "image/png" || att.BodyLength == 0 {
+			t.Errorf("Invalid attachment part!\nwant:%s\ngot:%s (%v bytes)", "image/png", att.ContentType, att.BodyLength)
+		}
+	})
+	t.Run("Test MIME tree doesn't change the email headers", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "MIME tree",
+			TextBody:   "Hello",
+		}
+		eml.SetHeader("X-Custom", "value")
+		if _, err := eml.MIMETree(); err != nil {
+			t.Fatal(err)
+		}
+		if len(eml.Headers) != 1 {
+			t.Errorf("Generated headers should not be added to the email!\nwant:%s\ngot:%v", "X-Custom", eml.Headers)
+		}
+	})
+	t.Run("Test MIME tree with non-seekable attachment", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "MIME tree",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("data.txt", io.MultiReader(strings.NewReader("data")), "text/plain")
+		if _, err := eml.MIMETree(); !errors.Is(err, ErrNonSeekableReader) {
+			t.Errorf("Expected the non-seekable reader error!\nwant:%s\ngot:%v", ErrNonSeekableReader, err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("data")); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment data should not be read by MIMETree!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package raweml
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// MaxRecipients is the maximum number of the destinations (To, Cc and Bcc) of a single email accepted by AWS SES
+const MaxRecipients = 50
+
+// Errors returned by PreflightSES
+var (
+	ErrMissingFrom           = errors.New("From address is required.")
+	ErrTooManyRecipients     = errors.New("Too many recipients")
+	ErrMessageTooLarge       = errors.New("Message is too large")
+	ErrBlockedAttachmentType = errors.New("Attachment type is not allowed by SES")
+)
+
+// blockedExtensions are the attachment file extensions rejected by AWS SES
+var blockedExtensions = map[string]bool{
+	"ade": true, "adp": true, "app": true, "asp": true, "bas": true, "bat": true, "cer": true, "chm": true, "cmd": true,
+	"com": true, "cpl": true, "crt": true, "csh": true, "der": true, "exe": true, "fxp": true, "gadget": true, "hlp": true,
+	"hta": true, "inf": true, "ins": true, "isp": true, "its": true, "js": true, "jse": true, "ksh": true, "lib": true,
+	"lnk": true, "mad": true, "maf": true, "mag": true, "mam": true, "maq": true, "mar": true, "mas": true, "mat": true,
+	"mau": true, "mav": true, "maw": true, "mda": true, "mdb": true, "mde": true, "mdt": true, "mdw": true, "mdz": true,
+	"msc": true, "msh": true, "msh1": true, "msh2": true, "mshxml": true, "msh1xml": true, "msh2xml": true, "msi": true,
+	"msp": true, "mst": true, "ops": true, "pcd": true, "pif": true, "plg": true, "prf": true, "prg": true, "reg": true,
+	"scf": true, "scr": true, "sct": true, "shb": true, "shs": true, "sys": true, "ps1": true, "ps1xml": true, "ps2": true,
+	"ps2xml": true, "psc1": true, "psc2": true, "tmp": true, "url": true, "vb": true, "vbe": true, "vbs": true, "vps": true,
+	"vsmacros": true, "vss": true, "vst": true, "vsw": true, "vxd": true, "ws": true, "wsc": true, "wsf": true, "wsh": true,
+	"xnk": true,
+}
+
+// PreflightSES runs the local checks mirroring the common AWS SES rejections and returns all the problems found
+// (nil when the email passes): missing From address, no destination, more than MaxRecipients destinations,
+// blocked attachment types and the composed message larger than MaxMessageSize.
+// The size is checked only when the other checks pass because it requires composing the email (see Email.Size).
+// The non-seekable attachment and HTML body readers are not read, so the estimated size is checked instead when the email has them
+// (use Email.Prepare() first to check the exact size).
+func (email Email) PreflightSES() []error {
+	var errs []error
+	if len(strings.TrimSpace(email.From)) == 0 {
+		errs = append(errs, ErrMissingFrom)
+	}
+	switch n := len(email.destinations()); {
+	case n == 0:
+		errs = append(errs, ErrNoRecipients)
+	case n > MaxRecipients:
+		errs = append(errs, fmt.Errorf("%w: %d destinations exceed the limit of %d.", ErrTooManyRecipients, n, MaxRecipients))
+	}
+	for i, item := range email.Attachments {
+		name := item.fileName(i)
+		if ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")); blockedExtensions[ext] {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrBlockedAttachmentType, name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	size, err := email.preflightSize()
+	if err != nil {
+		return []error{err}
+	}
+	if err := checkMessageSize(size); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// preflightSize returns the size of the composed email or the estimated size if the email has the non-seekable readers
+func (email Email) preflightSize() (int64, error) {
+	if err := email.checkOneShot(); err != nil {
+		return email.estimatedSize()
+	}
+	size, err := email.Size()
+	return int64(size), err
+}
@@ -0,0 +1,114 @@
+package raweml
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPreflightSES(t *testing.T) {
+	t.Run("Test valid email", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Preflight",
+			TextBody:   "Hello",
+		}
+		if errs := eml.PreflightSES(); len(errs) != 0 {
+			t.Errorf("Valid email should pass!\ngot:%v", errs)
+		}
+	})
+	t.Run("Test too many recipients", func(t *testing.T) {
+		addrs := make([]string, 60)
+		for i := range addrs {
+			addrs[i] = fmt.Sprintf("user%d@example.com", i)
+		}
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients(strings.Join(addrs, ","), "", ""),
+			Subject:    "Preflight",
+			TextBody:   "Hello",
+		}
+		errs := eml.PreflightSES()
+		if len(errs) != 1 || !errors.Is(errs[0], ErrTooManyRecipients) {
+			t.Errorf("Expected the recipient count error!\nwant:%s\ngot:%v", ErrTooManyRecipients, errs)
+		}
+	})
+	t.Run("Test multiple problems", func(t *testing.T) {
+		eml := Email{TextBody: "Hello"}
+		eml.AttachBytes("setup.exe", []byte("MZ"), "")
+		errs := eml.PreflightSES()
+		for _, want := range []error{ErrMissingFrom, ErrNoRecipients, ErrBlockedAttachmentType} {
+			found := false
+			for _, err := range errs {
+				found = found || errors.Is(err, want)
+			}
+			if !found {
+				t.Errorf("Missing preflight error!\nwant:%s\ngot:%v", want, errs)
+			}
+		}
+	})
+	t.Run("Test email is not changed", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Preflight",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("data.txt", io.MultiReader(strings.NewReader("data")), "text/plain")
+		if errs := eml.PreflightSES(); len(errs) != 0 {
+			t.Errorf("Valid email should pass!\ngot:%v", errs)
+		}
+		if len(eml.Headers) != 0 {
+			t.Errorf("PreflightSES should not change the email headers!\ngot:%v", eml.Headers)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("data")); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment data should not be read by PreflightSES!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test non-seekable HTML body is not read", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Preflight",
+		}
+		eml.SetHTMLBodyReader(io.MultiReader(strings.NewReader("<p>Hello</p>")))
+		if errs := eml.PreflightSES(); len(errs) != 0 {
+			t.Errorf("Valid email should pass!\ngot:%v", errs)
+		}
+		if _, err := eml.Size(); !errors.Is(err, ErrNonSeekableReader) {
+			t.Errorf("Expected the non-seekable reader error!\nwant:%s\ngot:%v", ErrNonSeekableReader, err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "<p>Hello</p>") {
+			t.Errorf("HTML body should not be read by PreflightSES!\ngot:%s", data)
+		}
+	})
+	t.Run("Test prepared HTML body", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Preflight",
+		}
+		eml.SetHTMLBodyReader(io.MultiReader(strings.NewReader("<p>Hello</p>")))
+		if err := eml.Prepare(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := eml.Size(); err != nil {
+			t.Errorf("Failed to get the size of the prepared email!\ngot:%v", err)
+		}
+		if eml.HTMLBody != "<p>Hello</p>" {
+			t.Errorf("Invalid prepared HTML body!\nwant:%s\ngot:%s", "<p>Hello</p>", eml.HTMLBody)
+		}
+	})
+}
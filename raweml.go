@@ -2,40 +2,100 @@
 package raweml
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"html"
+	"html/template"
 	"io"
+	"io/fs"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"net/mail"
 	"net/textproto"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
 	"github.com/google/uuid"
 )
 
 // Email is the structure containing all email details.
 // To send the email just call the Send() method.
 type Email struct {
-	From        string
-	Recipients  Recipients
-	Feedback    string // feedback destination email address. If left blank "Return-path" or "From" address will be used instead.
-	Subject     string // to change subject Charset use the MIME encoded-word syntax (e.g. "=?utf-8?B?5L2g5aW9?=") (ref: https://docs.aws.amazon.com/ses/latest/dg/send-email-raw.html)
-	TextBody    string
-	HTMLBody    string
-	CharSet     string
-	Attachments []Attachment // set it to `nil` if there are no attachments
-	Headers     textproto.MIMEHeader
-	Priority    EmailPriority
-	Topic       string
-	InReplyTo   string // Message-ID of the email to reply to in order for the email to be threaded. Gmail requires direct connection between emails to be threaded. Outlook is using Thread-Index and Thread-Topic instead
-	AwsRegion   string // AWS Region of the SES service
+	From               string
+	Recipients         Recipients
+	EnvelopeRecipients Recipients // Optional. When set the email is delivered to these recipients (SendRawEmailInput.Destinations) while the Recipients are used only for the To, Cc and Bcc headers
+	Feedback           string     // feedback destination email address for the bounce and complaint notifications (Return-Path). It must be a valid address. If left blank "Return-path" or "From" address will be used instead.
+	Subject            string     // non-ASCII subject is MIME encoded as UTF-8. To use another Charset set the subject in the MIME encoded-word syntax (e.g. "=?utf-8?B?5L2g5aW9?=") (ref: https://docs.aws.amazon.com/ses/latest/dg/send-email-raw.html)
+	TextBody           string
+	HTMLBody           string
+	CharSet            string
+	TextCharSet        string       // Optional. Overrides the CharSet for the text body
+	HTMLCharSet        string       // Optional. Overrides the CharSet for the HTML body
+	TextBodyFileName   string       // Optional. File name of the text body part written as `Content-Disposition: inline; filename="..."` so clients can offer the body for download
+	BodyEncoding       string       // Optional. Content-Transfer-Encoding of the text and HTML bodies. Set it to "base64" for the gateways that mangle the long lines. By default the bodies are sent as 7bit or 8bit
+	HTMLBodyFileName   string       // Optional. File name of the HTML body part (e.g. "body.html") written as `Content-Disposition: inline; filename="..."`. The disposition is omitted by default
+	SanitizeHTML       bool         // When true only the allowed tags, attributes and URL schemes (http, https, mailto and cid) are kept in the HTMLBody. Scripts, styles, svg and math are removed with their content. Inline styles and "cid:" images are kept
+	RawBody            []byte       // Optional. Pre-built MIME body (e.g. signed S/MIME content) sent verbatim as the whole message body. When set the TextBody, HTMLBody and Attachments are ignored
+	RawContentType     string       // Content-Type of the RawBody including its parameters (e.g. `multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256; boundary="b1"`)
+	Attachments        []Attachment // set it to `nil` if there are no attachments. Attachments are emitted after the body in the exact order of the slice
+	SortAttachments    bool         // When true the attachments are emitted sorted by their names (stable, so attachments with the same name keep their order)
+	Headers            textproto.MIMEHeader
+	Priority           EmailPriority
+	Topic              string            // Optional. Conversation topic used for the Thread-Topic (see Thread.OutlookTopic) and Thread-Index headers. The threading headers are omitted when the topic is blank, whitespace only or only the reply and forward prefixes (e.g. "RE:")
+	ThreadNamespace    uuid.UUID         // Optional. Namespace used to derive the thread GUID from the Topic (see NewThreadInNamespace). Defaults to the package namespace
+	InReplyTo          string            // Message-ID of the email to reply to in order for the email to be threaded. Gmail requires direct connection between emails to be threaded. Outlook is using Thread-Index and Thread-Topic instead. Multiple angle-bracketed Message-IDs are separated by spaces (e.g. "<id1@example.com> <id2@example.com>")
+	References         []string          // Optional. Message-IDs of the previous emails in the conversation (e.g. "<id@example.com>") written to the References header. When empty the InReplyTo is used instead
+	MessageID          string            // Optional. Message-ID header value (e.g. "<id@example.com>"). See GetMessageID()
+	IdempotencyKey     string            // Optional. Caller provided deduplication token written to the X-Idempotency-Key header. When MessageID is blank the Message-ID is derived from it so a retried email has the same Message-ID.
+	Organization       string            // Optional. Organization header value
+	Mailer             string            // Optional. X-Mailer header value. Defaults to "raweml/<Version>"
+	Precedence         string            // Optional. Precedence header value ("bulk", "list" or "junk") used to hint auto-responders and spam filters
+	ReadReceiptTo      string            // Optional. Address to send the read receipt to (Disposition-Notification-To and Return-Receipt-To headers)
+	AutoSubmitted      string            // Optional. Auto-Submitted header value (e.g. "auto-generated", "auto-replied") that prevents auto-responders from replying (RFC 3834)
+	EnvelopeFrom       string            // Optional. Envelope sender (MAIL FROM) address used for the Return-Path header and SendRawEmailInput.Source (see UseEnvelopeSender). It can differ from the From address (e.g. VERP bounce address).
+	UseEnvelopeSender  bool              // When true the envelope sender (SendRawEmailInput.Source) is explicitly set to the EnvelopeFrom or From address instead of letting SES infer it from the headers
+	FeedbackAsSource   bool              // When true and the Feedback is set, the SendRawEmailInput.Source is set to the Feedback address so the bounces and complaints are routed there. When false SES infers the Source from the headers.
+	Minimal            bool              // When true only the required and explicitly set headers are added (no default Content-Language, X-Mailer, Return-Path or X-Priority)
+	AuditHeaders       bool              // When true the composed email is parsed and an error is returned if a singleton header (e.g. MIME-Version, Content-Type) appears more than once
+	KeepHeaderOrder    bool              // When true, headers set with SetHeader are written after the standard headers in the order they were set and with their original casing
+	Language           *string           // Content-Language header value (e.g. aws.String("fr-FR")). When nil defaults to "en-US", when set to blank string the header is omitted.
+	ConfigurationSet   string            // Optional. SES configuration set name written to the X-SES-CONFIGURATION-SET header
+	MessageTags        map[string]string // Optional. SES message tags (name/value) written to the X-SES-MESSAGE-TAGS header
+	SourceArn          string            // Optional. SES sending authorization ARN of the identity associated with the envelope sender (SendRawEmailInput.SourceArn)
+	FromArn            string            // Optional. SES sending authorization ARN of the identity associated with the From address (SendRawEmailInput.FromArn)
+	ReturnPathArn      string            // Optional. SES sending authorization ARN of the identity associated with the Return-Path address (SendRawEmailInput.ReturnPathArn)
+	AwsRegion          string            // AWS Region of the SES service
+	Timeout            time.Duration     // Optional. When set the SES call is canceled if it doesn't complete within the timeout
+
+	headerOrder     []string                // keys (original casing) of the headers set with SetHeader in the order they were set
+	htmlBodySource  func(w io.Writer) error // renders the HTML body set with SetHTMLBodyReader or SetHTMLBodyTemplate
+	htmlBodyOneShot bool                    // true if the htmlBodySource reads a non-seekable reader
+}
+
+// destinations returns the addresses the email is delivered to (EnvelopeRecipients if set, otherwise Recipients)
+func (email Email) destinations() []*string {
+	if !email.EnvelopeRecipients.IsEmpty() {
+		return email.EnvelopeRecipients.All()
+	}
+	return email.Recipients.All()
 }
 
 // Recipients contains list of To, Cc, Bcc recipients
@@ -48,11 +108,13 @@ type Recipients struct {
 
 // Attachment represents an email attachment.
 type Attachment struct {
-	Name        string    // Name of the attachment
-	Data        io.Reader // reader for the attachment. WARNING do not set this value to a nil *bytes.Buffer it will not be same as nil io.Reader and it will cause panic.
+	Name        string    // Name of the attachment. When blank the ContentType name parameter, the base of the FileName or the generated "attachment-N" name is used
+	Data        io.Reader // reader for the attachment. Seekable readers are rewound before use, non-seekable readers can be read only once unless Email.Prepare() is called. Use Attachment.Reusable() to share the attachment by many emails. WARNING do not set this value to a nil *bytes.Buffer it will not be same as nil io.Reader and it will cause panic.
 	FileName    string    // Name must be set to a valid fully qulified file name. If the FileName is set the Data reader will be ignored.
 	ContentID   string    // Optional. Used for embedding images into the email (e.g. <img src="cid:{{ContentID}}">)
-	ContentType string    // Optional. When blank falls back to 'application/octet-stream'.
+	ContentType string    // Optional. When blank falls back to 'application/octet-stream'. The name parameter is replaced by the Name when they differ so it always agrees with the Content-Disposition filename.
+	Description string    // Optional. Human-readable description of the attachment (Content-Description header). Non-ASCII text will be MIME encoded.
+	Inline      bool      // Optional. When true the attachment is sent with "inline" disposition (e.g. images embedded in the HTML body)
 }
 
 // EmailPriority defines the type of priorty for the email
@@ -65,19 +127,67 @@ const (
 	PriorityLow    EmailPriority = "Low"
 )
 
+// Version of the raweml package
+const Version = "1.0.0"
+
 const crlf = "\r\n"
 
+// MaxMessageSize is the maximum size of the raw email accepted by AWS SES (10 MB)
+const MaxMessageSize = 10 * 1024 * 1024
+
 // Unique Application GUID used for defining the email conversation thread.
 var (
 	nameSpaceAppID = uuid.Must(uuid.Parse("9e01b615-a6a4-4883-b9bd-c1c80f4cceb4"))
 )
 
+// Errors returned when the email cannot be composed
+var (
+	ErrEmptyEmail            = errors.New("Cannot send empty email")
+	ErrNoRecipients          = errors.New("At least one of the TO, CC  and BCC is required to send email.")
+	ErrMultipleHeaderValues  = errors.New("Multiple header values are not supported.")
+	ErrAttachmentMissingData = errors.New("Attachment Data and FileName are missing. At least one of them is required.")
+	ErrInvalidHeader         = errors.New("Header contains CR, LF or NUL characters")
+	ErrInvalidRecipient      = errors.New("Invalid recipient")
+	ErrInvalidMessageID      = errors.New("Invalid Message-ID")
+	ErrSESHeaderConflict     = errors.New("SES header conflicts with the email field")
+	ErrNonSeekableReader     = errors.New("Non-seekable reader cannot be read more than once. Call Prepare() first.")
+)
+
+// Logger is called at the key points of composing and sending the email (e.g. email composed, send attempt, send result).
+// It is a no-op by default. Set it to log.Printf or similar function to observe the package.
+var Logger func(format string, v ...interface{})
+
+// logf writes the message to the Logger if it is set
+func logf(format string, v ...interface{}) {
+	if Logger != nil {
+		Logger(format, v...)
+	}
+}
+
+// newSESClient creates the SES client used to send the email in the AWS region
+var newSESClient = func(region string) sesiface.SESAPI {
+	return ses.New(session.New(&aws.Config{
+		Region: aws.String(region),
+	}))
+}
+
 // Send sends the email using the AWS SES
 func Send(email Email) error {
 	_, err := email.Send()
 	return err
 }
 
+// SendTo sends the HTML email to a single recipient using the AWS SES
+func SendTo(region, from, to, subject, htmlBody string) error {
+	return Send(Email{
+		From:       from,
+		Recipients: NewRecipients(to, "", ""),
+		Subject:    subject,
+		HTMLBody:   htmlBody,
+		AwsRegion:  region,
+	})
+}
+
 // NewRecipients converts comma separated list of to, cc and bcc into Recipients structure
 func NewRecipients(to string, cc string, bcc string) (r Recipients) {
 	if len(to) > 0 {
@@ -98,6 +208,35 @@ func NewRecipients(to string, cc string, bcc string) (r Recipients) {
 	return r
 }
 
+// Validate returns an error if any of the recipients contains control characters (e.g. CR or LF that could inject other headers)
+func (r Recipients) Validate() error {
+	for _, addr := range toStringArray(r.All()) {
+		if strings.IndexFunc(addr, unicode.IsControl) >= 0 {
+			return fmt.Errorf("%w %q: control characters are not allowed.", ErrInvalidRecipient, addr)
+		}
+	}
+	return nil
+}
+
+// RecipientsFromSlices converts lists of to, cc and bcc addresses into Recipients structure. Blank addresses are skipped.
+func RecipientsFromSlices(to, cc, bcc []string) (r Recipients) {
+	r.ToAddresses = toStringPointers(to)
+	r.CcAddresses = toStringPointers(cc)
+	r.BccAddresses = toStringPointers(bcc)
+	return r
+}
+
+// toStringPointers converts string array to an array of trimmed string pointers skipping the blank strings
+func toStringPointers(a []string) []*string {
+	var r []*string
+	for _, s := range a {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			r = append(r, aws.String(s))
+		}
+	}
+	return r
+}
+
 // String converts Recipients structure to a string with comma separated recipients
 func (r Recipients) String() string {
 	return strings.Join(toStringArray(r.All()), ",")
@@ -108,11 +247,37 @@ func (r Recipients) IsEmpty() bool {
 	return len(r.ToAddresses) == 0 && len(r.CcAddresses) == 0 && len(r.BccAddresses) == 0
 }
 
+// Count returns the total number of To, Cc and Bcc recipients
+func (r Recipients) Count() int {
+	return len(r.ToAddresses) + len(r.CcAddresses) + len(r.BccAddresses)
+}
+
+// Contains returns true if the email address is one of the To, Cc or Bcc recipients.
+// Only the address part is compared (case-insensitive) so "John <john@example.com>" contains "JOHN@example.com".
+func (r Recipients) Contains(addr string) bool {
+	addr = bareAddress(strings.TrimSpace(addr))
+	for _, s := range toStringArray(r.All()) {
+		if strings.EqualFold(bareAddress(strings.TrimSpace(s)), addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // All returns all recipients as an array of string pointers
 func (r Recipients) All() []*string {
 	return append(r.ToAddresses, append(r.CcAddresses, r.BccAddresses...)...)
 }
 
+// clone returns a copy of the recipients that doesn't share any addresses with the original
+func (r Recipients) clone() Recipients {
+	return Recipients{
+		BccAddresses: aws.StringSlice(toStringArray(r.BccAddresses)),
+		CcAddresses:  aws.StringSlice(toStringArray(r.CcAddresses)),
+		ToAddresses:  aws.StringSlice(toStringArray(r.ToAddresses)),
+	}
+}
+
 // toStringArray converts array of string pointers to string array
 func toStringArray(a []*string) []string {
 	var r []string
@@ -136,15 +301,13 @@ func (r Recipients) To() string { return strings.Join(toStringArray(r.ToAddresse
 // Send sends the email
 func (email Email) Send() (*ses.SendRawEmailOutput, error) {
 	// create session
-	svc := ses.New(session.New(&aws.Config{
-		Region: aws.String(email.AwsRegion),
-	}))
+	svc := newSESClient(email.AwsRegion)
 	// send email
 	return email.SendWithSession(svc, nil)
 }
 
 // SendWithSession sends the email using provided svc session
-func (email Email) SendWithSession(svc *ses.SES, input *ses.SendRawEmailInput) (result *ses.SendRawEmailOutput, err error) {
+func (email Email) SendWithSession(svc sesiface.SESAPI, input *ses.SendRawEmailInput) (result *ses.SendRawEmailOutput, err error) {
 	if svc == nil {
 		return nil, errors.New("Missing session parameter for SendWithInput function!")
 	}
@@ -153,266 +316,1458 @@ func (email Email) SendWithSession(svc *ses.SES, input *ses.SendRawEmailInput) (
 			return nil, err
 		}
 	}
-	return svc.SendRawEmail(input)
+	logf("raweml: sending email to %d destination(s)", len(input.Destinations))
+	if email.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), email.Timeout)
+		defer cancel()
+		result, err = svc.SendRawEmailWithContext(ctx, input)
+	} else {
+		result, err = svc.SendRawEmail(input)
+	}
+	if err != nil {
+		logf("raweml: sending email failed: %v", err)
+		return nil, err
+	}
+	logf("raweml: email sent (MessageId: %s)", aws.StringValue(result.MessageId))
+	return result, nil
+}
+
+// SendMerge sends a separate email to each recipient of the merge map with the recipient's {{placeholders}}
+// replaced in the Subject, TextBody and HTMLBody (values are HTML escaped in the HTMLBody).
+// The merge map is keyed by the recipient address and the values are the substitutions (e.g. {"name": "Alice"} for {{name}}).
+// The emails are sent in the order of the recipient addresses and the sending stops at the first error.
+func (email Email) SendMerge(ctx context.Context, svc sesiface.SESAPI, merge map[string]map[string]string) error {
+	if svc == nil {
+		return errors.New("Missing session parameter for SendMerge function!")
+	}
+	addrs := make([]string, 0, len(merge))
+	for addr := range merge {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var textPairs, htmlPairs []string
+		for key, value := range merge[addr] {
+			textPairs = append(textPairs, "{{"+key+"}}", value)
+			htmlPairs = append(htmlPairs, "{{"+key+"}}", html.EscapeString(value))
+		}
+		textReplacer, htmlReplacer := strings.NewReplacer(textPairs...), strings.NewReplacer(htmlPairs...)
+
+		personal := email.cloneForRecipient(addr)
+		personal.Subject = textReplacer.Replace(email.Subject)
+		personal.TextBody = textReplacer.Replace(email.TextBody)
+		personal.HTMLBody = htmlReplacer.Replace(email.HTMLBody)
+
+		input, err := personal.GetSendRawEmailInput()
+		if err != nil {
+			return fmt.Errorf("Failed to compose email to %s: %w", addr, err)
+		}
+		logf("raweml: sending merged email to %s", addr)
+		if _, err := svc.SendRawEmailWithContext(ctx, input); err != nil {
+			logf("raweml: sending merged email to %s failed: %v", addr, err)
+			return fmt.Errorf("Failed to send email to %s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// SendAll sends a separate email to each To recipient (Cc and Bcc recipients are not used).
+// A failure doesn't stop sending to the other recipients. The returned map contains the errors of the failed recipients
+// and it is empty if all the emails were sent.
+func (email Email) SendAll(ctx context.Context, svc sesiface.SESAPI) map[string]error {
+	failed := make(map[string]error)
+	if svc == nil {
+		for _, addr := range toStringArray(email.Recipients.ToAddresses) {
+			failed[addr] = errors.New("Missing session parameter for SendAll function!")
+		}
+		return failed
+	}
+	for _, addr := range toStringArray(email.Recipients.ToAddresses) {
+		if err := ctx.Err(); err != nil {
+			failed[addr] = err
+			continue
+		}
+		input, err := email.cloneForRecipient(addr).GetSendRawEmailInput()
+		if err == nil {
+			logf("raweml: sending email to %s", addr)
+			_, err = svc.SendRawEmailWithContext(ctx, input)
+		}
+		if err != nil {
+			logf("raweml: sending email to %s failed: %v", addr, err)
+			failed[addr] = err
+		}
+	}
+	return failed
+}
+
+// SendResult is the result of sending one of the emails with SendConcurrent
+type SendResult struct {
+	Output *ses.SendRawEmailOutput
+	Err    error
+}
+
+// SendConcurrent sends the emails using at most concurrency parallel workers (at least one).
+// The returned results are in the same order as the emails. Once the context is done the emails
+// that were not sent yet get the context error.
+func SendConcurrent(ctx context.Context, svc sesiface.SESAPI, emails []Email, concurrency int) []SendResult {
+	results := make([]SendResult, len(emails))
+	if svc == nil {
+		for i := range results {
+			results[i].Err = errors.New("Missing session parameter for SendConcurrent function!")
+		}
+		return results
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(emails); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = emails[i].sendWithContext(ctx, svc)
+			}
+		}()
+	}
+	for i := range emails {
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// sendWithContext composes and sends the email unless the context is done
+func (email Email) sendWithContext(ctx context.Context, svc sesiface.SESAPI) SendResult {
+	if err := ctx.Err(); err != nil {
+		return SendResult{Err: err}
+	}
+	input, err := email.GetSendRawEmailInput()
+	if err != nil {
+		return SendResult{Err: err}
+	}
+	logf("raweml: sending email to %d destination(s)", len(input.Destinations))
+	output, err := svc.SendRawEmailWithContext(ctx, input)
+	if err != nil {
+		logf("raweml: sending email failed: %v", err)
+	}
+	return SendResult{Output: output, Err: err}
+}
+
+// cloneForRecipient returns the clone of the email with the single To recipient and its own Message-ID
+func (email Email) cloneForRecipient(addr string) Email {
+	r := email.Clone()
+	r.Recipients = NewRecipients(addr, "", "")
+	r.EnvelopeRecipients = Recipients{}
+	r.MessageID = ""
+	if len(email.IdempotencyKey) > 0 {
+		r.IdempotencyKey = email.IdempotencyKey + "/" + addr
+	}
+	return r
 }
 
 // GetSendRawEmailInput converts the email to *ses.SendRawEmailInput structure required by ses.SendRawEmail() method
 func (email Email) GetSendRawEmailInput() (*ses.SendRawEmailInput, error) {
 
+	// check the SES size limit before reading any attachments
+	size, err := email.estimatedSize()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMessageSize(size); err != nil {
+		return nil, err
+	}
+
 	// get whole email content as bytes
 	emailBytes, err := email.Bytes()
 	if err != nil {
 		return nil, err
 	}
+	if err := checkMessageSize(int64(len(emailBytes))); err != nil {
+		return nil, err
+	}
 
 	// return SendRawEmailInput
-	return &ses.SendRawEmailInput{
+	input := &ses.SendRawEmailInput{
 		// Source:       email.GetSource(),	// commented out to send feedback email the same way as SendEmail
-		Destinations: email.Recipients.All(),
+		Destinations: email.destinations(),
 		RawMessage: &ses.RawMessage{
 			Data: emailBytes,
 		},
-	}, nil
+	}
+	if len(email.SourceArn) > 0 {
+		input.SourceArn = aws.String(email.SourceArn)
+	}
+	if len(email.FromArn) > 0 {
+		input.FromArn = aws.String(email.FromArn)
+	}
+	if len(email.ReturnPathArn) > 0 {
+		input.ReturnPathArn = aws.String(email.ReturnPathArn)
+	}
+	if email.UseEnvelopeSender {
+		if len(email.EnvelopeFrom) > 0 {
+			input.Source = aws.String(email.EnvelopeFrom)
+		} else if input.Source, err = email.GetSourceChecked(); err != nil {
+			return nil, err
+		}
+	} else if email.FeedbackAsSource && len(email.Feedback) > 0 {
+		input.Source = aws.String(email.Feedback)
+	}
+	return input, nil
+}
+
+// WriteTo writes the raw email data to w. It implements the io.WriterTo interface.
+func (email Email) WriteTo(w io.Writer) (int64, error) {
+	data, err := email.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Size returns the size in bytes of the composed raw email (e.g. to compare it with MaxMessageSize before sending)
+// The email is composed on a clone so it can still be sent afterwards. Non-seekable attachment and HTML body readers
+// would be consumed by composing, so Size returns ErrNonSeekableReader for them unless Prepare() is called first.
+func (email Email) Size() (int, error) {
+	if err := email.checkOneShot(); err != nil {
+		return 0, err
+	}
+	var counter countingWriter
+	if _, err := email.Clone().WriteTo(&counter); err != nil {
+		return 0, err
+	}
+	return int(counter), nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// SetHTMLBodyReader sets the HTML body to be read from r when the email is composed.
+// Seekable readers are rewound before use, non-seekable readers can be read only once (see Prepare).
+func (email *Email) SetHTMLBodyReader(r io.Reader) {
+	email.HTMLBody = ""
+	_, seekable := r.(io.Seeker)
+	email.htmlBodyOneShot = !seekable
+	email.htmlBodySource = func(w io.Writer) error {
+		if seeker, ok := r.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+}
+
+// SetHTMLBodyTemplate sets the HTML body to be rendered from the template with the data when the email is composed.
+func (email *Email) SetHTMLBodyTemplate(t *template.Template, data interface{}) {
+	email.HTMLBody = ""
+	email.htmlBodyOneShot = false
+	email.htmlBodySource = func(w io.Writer) error {
+		return t.Execute(w, data)
+	}
+}
+
+// renderHTMLBody renders the HTML body set with SetHTMLBodyReader or SetHTMLBodyTemplate into the HTMLBody
+func (email *Email) renderHTMLBody() error {
+	if email.htmlBodySource == nil {
+		return nil
+	}
+	body := new(strings.Builder)
+	if err := email.htmlBodySource(body); err != nil {
+		return fmt.Errorf("Failed to render the HTML body: %w", err)
+	}
+	email.HTMLBody = body.String()
+	email.htmlBodySource, email.htmlBodyOneShot = nil, false
+	return nil
+}
+
+// checkOneShot returns ErrNonSeekableReader if composing the email would consume a non-seekable attachment
+// or HTML body reader, so the email could not be composed again (e.g. sent after it is measured)
+func (email Email) checkOneShot() error {
+	if email.htmlBodySource != nil && email.htmlBodyOneShot {
+		return fmt.Errorf("%w: HTML body", ErrNonSeekableReader)
+	}
+	for i, item := range email.Attachments {
+		if item.isOneShot() {
+			return fmt.Errorf("%w: attachment %q", ErrNonSeekableReader, item.fileName(i))
+		}
+	}
+	return nil
 }
 
 // Bytes converts the email structure into email raw data bytes
 func (email Email) Bytes() ([]byte, error) {
-	// figure out the email parts
-	hasAttachment := len(email.Attachments) > 0
-	hasTxt := len(email.TextBody) > 0
-	hasHTML := len(email.HTMLBody) > 0
+	data, _, err := email.BytesWithBoundaries()
+	return data, err
+}
+
+// BytesWithBoundaries converts the email structure into email raw data bytes
+// and returns the multipart boundaries used in the raw data (outer boundary first).
+// Single-part emails have no boundaries.
+func (email Email) BytesWithBoundaries() ([]byte, []string, error) {
+	// render the HTML body set from a reader or template
+	if err := email.renderHTMLBody(); err != nil {
+		return nil, nil, err
+	}
+
+	// remove the scripts and dangerous elements from the HTML body
+	if email.SanitizeHTML {
+		email.HTMLBody = sanitizeHTML(email.HTMLBody)
+	}
+
+	// figure out the email parts (the raw body replaces all the other parts)
+	hasRaw := len(email.RawBody) > 0
+	hasAttachment := len(email.Attachments) > 0 && !hasRaw
+	hasTxt := len(email.TextBody) > 0 && !hasRaw
+	hasHTML := len(email.HTMLBody) > 0 && !hasRaw
 	hasAlternative := hasTxt && hasHTML
 
 	// validate the email
-	if !(hasAttachment || hasTxt || hasHTML || hasAlternative) {
-		return nil, errors.New("Cannot send empty email")
-	}
-	if email.Recipients.IsEmpty() {
-		return nil, errors.New("At least one of the TO, CC  and BCC is required to send email.")
+	if err := email.Validate(); err != nil {
+		return nil, nil, err
 	}
 
 	buf := new(bytes.Buffer)
 	var writer *multipart.Writer
+	var boundaries []string
 
-	// set Header attributes
-	h := email.GetHeaders()
+	// set Header attributes on a copy so the generated headers are not written back to the email.Headers
+	headers := make(textproto.MIMEHeader, len(email.Headers))
+	for k, vs := range email.Headers {
+		headers[k] = append([]string(nil), vs...)
+	}
+	h := &headers
 
-	setIfMissing(h, "From", email.From)
+	setIfMissing(h, "From", email.getFrom())
+	setIfMissing(h, "Sender", email.getSender())
 	setIfMissing(h, "To", email.Recipients.To())
 	setIfMissing(h, "Cc", email.Recipients.Cc())
 	setIfMissing(h, "Bcc", email.Recipients.Bcc())
-	setIfMissing(h, "Return-Path", email.Feedback)
-	setIfMissing(h, "Subject", email.Subject)
+	setIfMissing(h, "Return-Path", email.getFeedback())
+	setIfMissing(h, "Subject", encodeHeaderValue(email.Subject))
 
-	// add Thread-Index
-	if len(email.Topic) > 0 {
-		thread := NewThread(email.Topic)
-		setIfMissing(h, "Thread-Topic", thread.GetTopic())
+	// add Thread-Topic and Thread-Index (skipped when the topic is blank or only the prefixes like "RE:" because its thread GUID is meaningless)
+	if thread := NewThreadInNamespace(email.ThreadNamespace, email.Topic); len(thread.OutlookTopic()) > 0 {
+		setIfMissing(h, "Thread-Topic", encodeHeaderValue(thread.OutlookTopic()))
 		setIfMissing(h, "Thread-Index", thread.String())
-		setIfMissing(h, "References", thread.Reference())
 	}
-	if len(email.InReplyTo) > 0 {
-		setIfMissing(h, "In-Reply-To", email.InReplyTo)
+	if ids, _ := email.inReplyToIDs(); len(ids) > 0 {
+		setIfMissing(h, "In-Reply-To", strings.Join(ids, " "))
 	}
+	if references := email.getReferences(); len(references) > 0 {
+		setIfMissing(h, "References", references)
+	}
+
+	// add Message-ID
+	setIfMissing(h, "Message-Id", email.getMessageID())
+	setIfMissing(h, "X-Idempotency-Key", email.IdempotencyKey)
 
 	// add Email Priority
-	if email.Priority != PriorityNormal {
+	if email.Priority != PriorityNormal && !(email.Minimal && len(email.Priority) == 0) {
 		setIfMissing(h, "Importance", email.Priority.String())
 		setIfMissing(h, "X-Priority", email.Priority.ToNumber())
-		// h.Set("X-MSMail-Priority", email.Priority.String())
+		setIfMissing(h, "X-MSMail-Priority", email.Priority.String())
 	}
 
+	// add Organization and X-Mailer
+	setIfMissing(h, "Organization", email.Organization)
+	setIfMissing(h, "X-Mailer", email.getMailer())
+
+	// add read receipt request
+	setIfMissing(h, "Disposition-Notification-To", email.ReadReceiptTo)
+	setIfMissing(h, "Return-Receipt-To", email.ReadReceiptTo)
+
+	// add Auto-Submitted and Precedence
+	setIfMissing(h, "Auto-Submitted", email.AutoSubmitted)
+	setIfMissing(h, "Precedence", email.Precedence)
+
+	// add SES configuration set and message tags
+	setIfMissing(h, "X-SES-CONFIGURATION-SET", email.ConfigurationSet)
+	setIfMissing(h, "X-SES-MESSAGE-TAGS", email.getMessageTags())
+
 	// add language
-	setIfMissing(h, "Content-Language", "en-US")
+	setIfMissing(h, "Content-Language", email.getLanguage())
 
 	// add multipart
-	if hasAttachment {
+	if hasRaw {
+		takeHeader(h, "Content-Type")
+		h.Set("Content-Type", email.RawContentType)
+	} else if hasAttachment {
 		writer = multipart.NewWriter(buf)
-		defer writer.Close() // this will not write the boundery because buffer is all ready flushed
-		h.Set("Content-Type", "multipart/mixed; boundary=\""+writer.Boundary()+"\"")
+		boundaries = append(boundaries, writer.Boundary())
+		h.Set("Content-Type", multipartContentType(takeHeader(h, "Content-Type"), "multipart/mixed", writer.Boundary()))
 	} else if hasAlternative {
 		writer = multipart.NewWriter(buf)
-		defer writer.Close()
-		h.Set("Content-Type", "multipart/alternative; boundary=\""+writer.Boundary()+"\"")
+		boundaries = append(boundaries, writer.Boundary())
+		h.Set("Content-Type", multipartContentType(takeHeader(h, "Content-Type"), "multipart/alternative", writer.Boundary()))
 	} else if hasTxt {
-		h.Set("Content-Type", "text/plain; charset="+email.getCharSet()) // us-ascii
+		setIfMissing(h, "Content-Type", "text/plain; charset="+email.getTextCharSet()) // us-ascii
+		setIfMissing(h, "Content-Transfer-Encoding", bodyTransferEncoding(email.TextBody, email.BodyEncoding))
+		setIfMissing(h, "Content-Disposition", bodyDisposition(email.TextBodyFileName))
 	} else if hasHTML {
-		h.Set("Content-Type", "text/html; charset="+email.getCharSet()) // UTF-8
+		setIfMissing(h, "Content-Type", "text/html; charset="+email.getHTMLCharSet()) // UTF-8
+		setIfMissing(h, "Content-Transfer-Encoding", bodyTransferEncoding(email.HTMLBody, email.BodyEncoding))
+		setIfMissing(h, "Content-Disposition", bodyDisposition(email.HTMLBodyFileName))
 	} else {
-		return nil, errors.New("Missing email content!")
+		return nil, nil, errors.New("Missing email content!")
 	}
 	setIfMissing(h, "MIME-Version", "1.0")
 
 	// write main Header
-	writeHeader(buf, h)
+	var ordered []string
+	if email.KeepHeaderOrder {
+		ordered = email.headerOrder
+	}
+	if err := writeHeader(buf, h, ordered); err != nil {
+		return nil, nil, err
+	}
 
 	// - alternative
 	if hasAlternative && hasAttachment {
 		// Nested Alternative parts
 		altWriter := multipart.NewWriter(buf)
-		defer altWriter.Close()
+		boundaries = append(boundaries, altWriter.Boundary())
 
 		hAlt := make(textproto.MIMEHeader)
 		hAlt.Set("Content-Type", "multipart/alternative; boundary=\""+altWriter.Boundary()+"\"")
 		hAlt.Set("MIME-Version", "1.0")
-		_, err := writer.CreatePart(hAlt)
+		_, err := createPart(writer, hAlt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// TEXT body
-		if err := addPart(altWriter, "text/plain; charset="+email.getCharSet(), email.TextBody); err != nil {
-			return nil, err
+		if err := addPart(altWriter, "text/plain; charset="+email.getTextCharSet(), email.TextBody, email.TextBodyFileName, email.BodyEncoding); err != nil {
+			return nil, nil, err
 		}
 
 		// HTML body:
-		if err := addPart(altWriter, "text/html; charset="+email.getCharSet(), email.HTMLBody); err != nil {
-			return nil, err
+		if err := addPart(altWriter, "text/html; charset="+email.getHTMLCharSet(), email.HTMLBody, email.HTMLBodyFileName, email.BodyEncoding); err != nil {
+			return nil, nil, err
+		}
+
+		// close the inner writer before the outer parts are written
+		if err := altWriter.Close(); err != nil {
+			return nil, nil, err
 		}
-		altWriter.Close()
 
 	} else if hasAlternative || hasAttachment {
 		// TEXT body
 		if hasTxt {
-			if err := addPart(writer, "text/plain; charset="+email.getCharSet(), email.TextBody); err != nil {
-				return nil, err
+			if err := addPart(writer, "text/plain; charset="+email.getTextCharSet(), email.TextBody, email.TextBodyFileName, email.BodyEncoding); err != nil {
+				return nil, nil, err
 			}
 		}
 
 		// HTML body:
 		if hasHTML {
-			if err := addPart(writer, "text/html; charset="+email.getCharSet(), email.HTMLBody); err != nil {
-				return nil, err
+			if err := addPart(writer, "text/html; charset="+email.getHTMLCharSet(), email.HTMLBody, email.HTMLBodyFileName, email.BodyEncoding); err != nil {
+				return nil, nil, err
 			}
 		}
 	} else {
-		if hasTxt {
-			buf.Write([]byte(email.TextBody))
+		if hasRaw {
+			buf.Write(email.RawBody)
+		} else if hasTxt {
+			buf.WriteString(encodeBody(email.TextBody, email.BodyEncoding))
 			fmt.Fprint(buf, crlf)
 		} else if hasHTML {
-			buf.Write([]byte(email.HTMLBody))
+			buf.WriteString(encodeBody(email.HTMLBody, email.BodyEncoding))
 			fmt.Fprint(buf, crlf)
 		} else {
-			return nil, errors.New("Email is empty!")
+			return nil, nil, errors.New("Email is empty!")
 		}
 	}
 
 	// Attachments (if there is any)
 	if hasAttachment {
-		if err := addAttachments(buf, email.Attachments, writer.Boundary()); err != nil {
-			return nil, err
+		if err := addAttachments(writer, email.orderedAttachments()); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	// done writing
+	// done writing: close the outer writer last
 	if writer != nil {
-		err := writer.Close()
-		if err != nil {
-			return nil, err
+		if err := writer.Close(); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	return buf.Bytes(), nil
-}
-
-// GetHeaders returns a pointer to the email.Headers field
-func (email Email) GetHeaders() *textproto.MIMEHeader {
-	if email.Headers == nil {
-		email.Headers = make(textproto.MIMEHeader)
+	// audit the composed headers
+	if email.AuditHeaders {
+		if err := auditHeaders(buf.Bytes()); err != nil {
+			return nil, nil, err
+		}
 	}
-	return &email.Headers
-}
 
-// SetHeader sets the header entries associated with key to the single element value. It replaces any existing values associated with key.
-func (email *Email) SetHeader(key, value string) {
-	h := email.GetHeaders()
-	h.Set(key, value)
+	logf("raweml: email composed (%d bytes)", buf.Len())
+	return buf.Bytes(), boundaries, nil
 }
 
-func addPart(writer *multipart.Writer, contentType string, body string) error {
+// singletonHeaders are the headers that may appear only once in the email (RFC 5322 and RFC 2045)
+var singletonHeaders = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Subject", "Date", "Message-Id", "In-Reply-To", "References",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
 
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Type", contentType)
-	h.Set("Content-Transfer-Encoding", "7bit")
-	part, err := writer.CreatePart(h)
+// auditHeaders parses the composed email and returns an error if any of the singleton headers appears more than once
+func auditHeaders(data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to parse the composed email: %v", err)
 	}
-	_, err = part.Write([]byte(body))
-	if err != nil {
-		return err
+	var dup []string
+	for _, key := range singletonHeaders {
+		if len(msg.Header[key]) > 1 {
+			dup = append(dup, key)
+		}
+	}
+	if len(dup) > 0 {
+		return fmt.Errorf("Duplicate email headers: %s", strings.Join(dup, ", "))
 	}
 	return nil
 }
 
-func _addAttachment(w io.Writer, item Attachment, boundary string) error {
-	contentType := item.ContentType
-	if len(contentType) == 0 {
-		contentType = "application/octet-stream"
+// Validate checks if the email has all the required fields and returns an error if it cannot be sent.
+// The SES size limit (MaxMessageSize) is checked only when the email is sent with SES (see GetSendRawEmailInput).
+func (email Email) Validate() error {
+	if len(email.Attachments) == 0 && len(email.TextBody) == 0 && len(email.HTMLBody) == 0 && email.htmlBodySource == nil && len(email.RawBody) == 0 {
+		return ErrEmptyEmail
 	}
-	fileReader := item.Data
-
-	if fileReader == nil || fileReader == (*bytes.Buffer)(nil) || fileReader == (*os.File)(nil) {
-		if len(item.FileName) > 0 {
-			file, err := os.Open(item.FileName)
-			if err != nil {
-				return err
-				// alternative: attach blank file
-				// fmt.Fprintf(w, "\n--%s\n", boundary)
-				// fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\n")
-				// fmt.Fprintf(w, "could not open file: %v\n", err)
-			}
-			fileReader = file
-			defer file.Close()
-		} else {
-			return errors.New("Attachment Data and FileName are missing. At least one of them is required.")
+	if len(email.RawBody) > 0 && len(email.RawContentType) == 0 {
+		return errors.New("RawContentType is required to send the RawBody.")
+	}
+	if email.Recipients.IsEmpty() && email.EnvelopeRecipients.IsEmpty() {
+		return ErrNoRecipients
+	}
+	if err := email.Recipients.Validate(); err != nil {
+		return err
+	}
+	if err := email.EnvelopeRecipients.Validate(); err != nil {
+		return err
+	}
+	if _, err := email.inReplyToIDs(); err != nil {
+		return err
+	}
+	if len(email.From) > 0 {
+		if _, err := mail.ParseAddressList(email.From); err != nil {
+			return fmt.Errorf("Invalid From address %q: %v", email.From, err)
 		}
 	}
-
-	fmt.Fprintf(w, "\n--%s\n", boundary)
-	fmt.Fprintf(w, "Content-Type: %s\n", contentType)
-	fmt.Fprintf(w, "Content-Transfer-Encoding: base64\n")
-	fmt.Fprintf(w, "Content-ID: <%s>\n", item.ContentID)
-	fmt.Fprintf(w, "X-Attachment-Id: %s\n", item.ContentID)
-	fmt.Fprintf(w, "Content-Disposition: attachment; filename=\"%s\"\n\n", filepath.Base(item.Name))
-
-	b64 := base64.NewEncoder(base64.StdEncoding, w)
-	defer b64.Close()
-
-	if _, err := io.Copy(b64, fileReader); err != nil {
+	if len(email.Feedback) > 0 {
+		if _, err := mail.ParseAddress(email.Feedback); err != nil {
+			return fmt.Errorf("Invalid Feedback address %q used for the bounce and complaint notifications (Return-Path): %v", email.Feedback, err)
+		}
+	}
+	for _, item := range email.Attachments {
+		if strings.IndexFunc(item.contentID(), func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return fmt.Errorf("Invalid attachment ContentID %q. Whitespace and control characters are not allowed.", item.ContentID)
+		}
+	}
+	switch email.BodyEncoding {
+	case "", "base64":
+	default:
+		return fmt.Errorf("Invalid BodyEncoding %q. Expected blank or base64.", email.BodyEncoding)
+	}
+	switch email.Precedence {
+	case "", "bulk", "list", "junk":
+	default:
+		return fmt.Errorf("Invalid Precedence %q. Expected one of: bulk, list, junk.", email.Precedence)
+	}
+	if len(email.ConfigurationSet) > 0 && (len(email.ConfigurationSet) > 64 || !isSESName(email.ConfigurationSet)) {
+		return fmt.Errorf("Invalid ConfigurationSet %q. Only up to 64 ASCII letters, numbers, underscores and dashes are allowed.", email.ConfigurationSet)
+	}
+	for name, value := range email.MessageTags {
+		if len(name) == 0 || len(name) > 256 || len(value) > 256 || !isSESName(name) || !isSESName(value) {
+			return fmt.Errorf("Invalid message tag %q=%q. Only up to 256 ASCII letters, numbers, underscores, dashes, periods and @ signs are allowed.", name, value)
+		}
+	}
+	if err := email.checkSESHeaders(); err != nil {
 		return err
 	}
-
-	// compress
-	// gzip := gzip.NewWriter(b64)
-	// defer gzip.Close()
-	// io.Copy(gzip, file)
-
-	return nil
-}
-
-func addAttachments(w io.Writer, attachments []Attachment, boundary string) error {
-	for _, item := range attachments {
-		if err := _addAttachment(w, item, boundary); err != nil {
+	if len(email.ReadReceiptTo) > 0 {
+		if _, err := mail.ParseAddress(email.ReadReceiptTo); err != nil {
+			return fmt.Errorf("Invalid ReadReceiptTo address %q: %v", email.ReadReceiptTo, err)
+		}
+	}
+	if len(email.EnvelopeFrom) > 0 {
+		if _, err := mail.ParseAddress(email.EnvelopeFrom); err != nil {
+			return fmt.Errorf("Invalid EnvelopeFrom address %q: %v", email.EnvelopeFrom, err)
+		}
+	}
+	if dup := duplicateContentIDs(email.Attachments); len(dup) > 0 {
+		return fmt.Errorf("Duplicate attachment ContentID: %s", strings.Join(dup, ", "))
+	}
+	// report the missing attachment files before composing the email
+	for _, item := range email.Attachments {
+		if _, err := item.size(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// writeHeader writes the specified MIMEHeader to the io.Writer.
-// Header values will be trimmed but otherwise left alone.
-// Headers with multiple values are not supported and will return an error.
-func writeHeader(w io.Writer, header *textproto.MIMEHeader) error {
-	// for k, vs := range *header {
-	for _, k := range sortedHeaders(header) {
-		vs := header.Values(k)
-		_, err := fmt.Fprintf(w, "%s: ", k)
+// estimatedSize estimates the size of the raw email without reading the attachments.
+// The attachments with unknown size (e.g. non-seekable readers) are not counted.
+func (email Email) estimatedSize() (int64, error) {
+	size := email.estimatedHeaderSize()
+	// the RawBody is sent as the whole body (e.g. the signed text, HTML and attachments)
+	if len(email.RawBody) > 0 {
+		return size + int64(len(email.RawBody)), nil
+	}
+	size += int64(len(email.TextBody) + len(email.HTMLBody))
+	for _, item := range email.Attachments {
+		n, err := item.size()
 		if err != nil {
-			return err
+			return 0, err
 		}
+		size += int64(base64.StdEncoding.EncodedLen(int(n)))
+	}
+	return size, nil
+}
 
-		for i, v := range vs {
+// estimatedHeaderSize estimates the size of the From, recipient, Subject and custom headers
+func (email Email) estimatedHeaderSize() int64 {
+	size := len(email.From) + len(email.Subject)
+	for _, r := range email.Recipients.All() {
+		size += len(aws.StringValue(r)) + 2
+	}
+	for key, values := range email.Headers {
+		for _, v := range values {
+			size += len(key) + len(v) + 4
+		}
+	}
+	return int64(size)
+}
+
+// checkMessageSize returns ErrMessageTooLarge if the size exceeds the SES limit (MaxMessageSize)
+func checkMessageSize(size int64) error {
+	if size > MaxMessageSize {
+		return fmt.Errorf("%w: email size (%d bytes) exceeds the maximum size of %d bytes.", ErrMessageTooLarge, size, MaxMessageSize)
+	}
+	return nil
+}
+
+// Reply creates a reply to the original email.
+// The reply is sent from the first original recipient to the original sender with the "RE:" subject prefix,
+// and it is threaded to the original email (In-Reply-To, References and the same Topic).
+func Reply(original Email, htmlBody, textBody string) Email {
+	reply := Email{
+		Subject:   original.Subject,
+		HTMLBody:  htmlBody,
+		TextBody:  textBody,
+		CharSet:   original.CharSet,
+		Topic:     original.Topic,
+		AwsRegion: original.AwsRegion,
+	}
+	if to := toStringArray(original.Recipients.ToAddresses); len(to) > 0 {
+		reply.From = to[0]
+	}
+	reply.Recipients = NewRecipients(original.From, "", "")
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(reply.Subject)), "re:") {
+		reply.Subject = "RE: " + reply.Subject
+	}
+	if len(reply.Topic) == 0 {
+		reply.Topic = NormalizeSubject(original.Subject)
+	}
+	if id := strings.Trim(strings.TrimSpace(original.getMessageID()), "<>"); len(id) > 0 {
+		id = "<" + id + ">"
+		reply.InReplyTo = id
+		reply.References = append(append([]string{}, original.References...), id)
+	}
+	return reply
+}
+
+// Forward creates a forward of the original email to the given recipients.
+// The original bodies are quoted below a forwarded message header and the original attachments are attached again.
+// Attachments Data readers are read into memory so the forward doesn't depend on the original readers
+// (attachments that fail to be read are logged and skipped).
+func Forward(original Email, to Recipients) Email {
+	forward := Email{
+		Recipients: to.clone(),
+		Subject:    original.Subject,
+		CharSet:    original.CharSet,
+		Topic:      original.Topic,
+		AwsRegion:  original.AwsRegion,
+	}
+	if addrs := toStringArray(original.Recipients.ToAddresses); len(addrs) > 0 {
+		forward.From = addrs[0]
+	}
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(forward.Subject)), "fw:") {
+		forward.Subject = "FW: " + forward.Subject
+	}
+	if len(forward.Topic) == 0 {
+		forward.Topic = NormalizeSubject(original.Subject)
+	}
+
+	intro := fmt.Sprintf("---------- Forwarded message ----------\r\nFrom: %s\r\nTo: %s\r\nSubject: %s\r\n", original.From, original.Recipients.To(), original.Subject)
+	if len(original.TextBody) > 0 {
+		quoted := strings.Split(strings.ReplaceAll(original.TextBody, "\r\n", "\n"), "\n")
+		for i, line := range quoted {
+			quoted[i] = "> " + line
+		}
+		forward.TextBody = intro + "\r\n" + strings.Join(quoted, "\r\n")
+	}
+	if len(original.HTMLBody) > 0 {
+		forward.HTMLBody = "<p>" + strings.ReplaceAll(html.EscapeString(strings.TrimSpace(intro)), "\r\n", "<br>") + "</p>\r\n<blockquote>" + original.HTMLBody + "</blockquote>"
+	}
+
+	for _, item := range original.Attachments {
+		if item.hasData() {
+			if seeker, ok := item.Data.(io.Seeker); ok {
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					logf("raweml: skipping forwarded attachment %q: %v", item.Name, err)
+					continue
+				}
+			}
+			data, err := io.ReadAll(item.Data)
+			if err != nil {
+				logf("raweml: skipping forwarded attachment %q: %v", item.Name, err)
+				continue
+			}
+			item.Data = bytes.NewReader(data)
+		}
+		forward.Attachments = append(forward.Attachments, item)
+	}
+	return forward
+}
+
+// UndisclosedRecipients hides the recipients by moving all of them to the EnvelopeRecipients (as Bcc)
+// and setting the visible To header to the empty "undisclosed-recipients:;" group.
+func (email *Email) UndisclosedRecipients() {
+	if email.EnvelopeRecipients.IsEmpty() {
+		email.EnvelopeRecipients = Recipients{BccAddresses: email.Recipients.All()}
+	}
+	email.Recipients = NewRecipients("undisclosed-recipients:;", "", "")
+}
+
+// RenderAsRecipient returns the raw email as it is seen by the To and Cc recipients (e.g. for a dry-run preview).
+// The Bcc recipients are removed from the headers (including the Bcc set in the Headers) and the destinations.
+// When there are no To and Cc recipients the To header is set to the "undisclosed-recipients:;" group.
+// It returns nil if the email cannot be composed or it has non-seekable readers that are not prepared (see Prepare).
+func (email Email) RenderAsRecipient() []byte {
+	if err := email.checkOneShot(); err != nil {
+		logf("raweml: rendering the email as recipient failed: %v", err)
+		return nil
+	}
+	preview := email.Clone()
+	preview.Recipients.BccAddresses = nil
+	preview.EnvelopeRecipients = Recipients{}
+	for k := range preview.Headers {
+		if textproto.CanonicalMIMEHeaderKey(k) == "Bcc" {
+			delete(preview.Headers, k)
+		}
+	}
+	if preview.Recipients.IsEmpty() {
+		preview.Recipients = NewRecipients("undisclosed-recipients:;", "", "")
+	}
+	data, err := preview.Bytes()
+	if err != nil {
+		logf("raweml: rendering the email as recipient failed: %v", err)
+		return nil
+	}
+	return data
+}
+
+// WithSES sets the SES configuration set and message tags and returns the email for chaining.
+// The values are validated when the email is composed (see Validate()).
+func (email *Email) WithSES(configSet string, tags map[string]string) *Email {
+	email.ConfigurationSet = configSet
+	email.MessageTags = tags
+	return email
+}
+
+// checkSESHeaders returns an error if the Headers contain the SES specific header that is also set by the dedicated email field
+// (e.g. the X-SES-MESSAGE-TAGS header and the MessageTags field) because only one of them would be used.
+func (email Email) checkSESHeaders() error {
+	fields := []struct {
+		header, field string
+		isSet         bool
+	}{
+		{"X-SES-MESSAGE-TAGS", "MessageTags", len(email.MessageTags) > 0},
+		{"X-SES-CONFIGURATION-SET", "ConfigurationSet", len(email.ConfigurationSet) > 0},
+		{"X-SES-SOURCE-ARN", "SourceArn", len(email.SourceArn) > 0},
+		{"X-SES-FROM-ARN", "FromArn", len(email.FromArn) > 0},
+		{"X-SES-RETURN-PATH-ARN", "ReturnPathArn", len(email.ReturnPathArn) > 0},
+		{"Return-Path", "Feedback", len(email.Feedback) > 0},
+	}
+	for _, f := range fields {
+		// the headers removed with RemoveHeader have no values and don't conflict
+		if f.isSet && hasHeaderValue(email.Headers, f.header) {
+			return fmt.Errorf("%w: the %s header is set together with the %s field. Use only one of them.", ErrSESHeaderConflict, f.header, f.field)
+		}
+	}
+	return nil
+}
+
+// getMessageTags returns the X-SES-MESSAGE-TAGS header value (e.g. "campaign=spring, team=sales")
+func (email Email) getMessageTags() string {
+	names := make([]string, 0, len(email.MessageTags))
+	for name := range email.MessageTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tags := make([]string, len(names))
+	for i, name := range names {
+		tags[i] = name + "=" + email.MessageTags[name]
+	}
+	return strings.Join(tags, ", ")
+}
+
+// isSESName returns true if the string contains only the characters allowed by SES in names and message tags
+func isSESName(s string) bool {
+	for _, r := range s {
+		if r >= utf8.RuneSelf || !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.@", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// inReplyToIDs returns the space-separated Message-IDs of the InReplyTo. Each Message-ID must be enclosed in angle brackets
+func (email Email) inReplyToIDs() ([]string, error) {
+	ids := strings.Fields(email.InReplyTo)
+	for _, id := range ids {
+		if len(id) < 3 || id[0] != '<' || id[len(id)-1] != '>' || strings.ContainsAny(id[1:len(id)-1], "<>") {
+			return nil, fmt.Errorf("%w %q in InReplyTo: Message-IDs must be enclosed in angle brackets.", ErrInvalidMessageID, id)
+		}
+	}
+	return ids, nil
+}
+
+// getReferences returns the References header value (space-separated list of angle-bracketed Message-IDs)
+func (email Email) getReferences() string {
+	ids := email.References
+	if len(ids) == 0 {
+		ids, _ = email.inReplyToIDs()
+	}
+	var refs []string
+	for _, id := range ids {
+		id = strings.Trim(strings.TrimSpace(id), "<>")
+		if len(id) > 0 {
+			refs = append(refs, "<"+id+">")
+		}
+	}
+	return strings.Join(refs, " ")
+}
+
+// GetMessageID returns the email Message-ID. If the MessageID field is blank a new Message-ID is generated and saved in the field
+// so the same Message-ID is used every time the email is composed.
+func (email *Email) GetMessageID() string {
+	if len(email.MessageID) == 0 {
+		email.MessageID = email.getMessageID()
+	}
+	if len(email.MessageID) == 0 {
+		email.MessageID = newMessageID(uuid.New(), email.From)
+	}
+	return email.MessageID
+}
+
+// getMessageID returns the MessageID field or the Message-ID derived from the IdempotencyKey
+func (email Email) getMessageID() string {
+	if len(email.MessageID) > 0 {
+		return email.MessageID
+	}
+	if len(email.IdempotencyKey) > 0 {
+		return newMessageID(uuid.NewSHA1(nameSpaceAppID, []byte(email.IdempotencyKey)), email.From)
+	}
+	return ""
+}
+
+// newMessageID returns the Message-ID in "<id@domain>" format where domain is the domain of the first From address
+func newMessageID(id uuid.UUID, from string) string {
+	domain := "raweml"
+	if addresses, err := mail.ParseAddressList(from); err == nil {
+		if i := strings.LastIndex(addresses[0].Address, "@"); i >= 0 {
+			domain = addresses[0].Address[i+1:]
+		}
+	}
+	return "<" + id.String() + "@" + domain + ">"
+}
+
+// Clone returns a deep copy of the email so it can be modified without affecting the original email.
+// NOTE: attachments Data readers are shared between the clones (see Email.Prepare()).
+func (email Email) Clone() Email {
+	r := email
+	r.Recipients = email.Recipients.clone()
+	r.EnvelopeRecipients = email.EnvelopeRecipients.clone()
+	r.References = append([]string(nil), email.References...)
+	if email.MessageTags != nil {
+		r.MessageTags = make(map[string]string, len(email.MessageTags))
+		for name, value := range email.MessageTags {
+			r.MessageTags[name] = value
+		}
+	}
+	if email.Headers != nil {
+		r.Headers = make(textproto.MIMEHeader, len(email.Headers))
+		for k, vs := range email.Headers {
+			r.Headers[k] = append([]string{}, vs...)
+		}
+	}
+	if email.Attachments != nil {
+		r.Attachments = append([]Attachment{}, email.Attachments...)
+	}
+	if email.Language != nil {
+		r.Language = aws.String(*email.Language)
+	}
+	r.headerOrder = append([]string(nil), email.headerOrder...)
+	return r
+}
+
+// GetHeaders returns a pointer to the email.Headers field
+func (email Email) GetHeaders() *textproto.MIMEHeader {
+	if email.Headers == nil {
+		email.Headers = make(textproto.MIMEHeader)
+	}
+	return &email.Headers
+}
+
+// FinalHeaders returns a copy of the headers as they appear in the composed email, including the headers added
+// by the library (e.g. From, To, Subject, Message-Id, Mime-Version and Content-Type). Headers added by SES
+// at send time (e.g. Date) are not included. Changing the returned headers doesn't change the email.
+// It returns ErrNonSeekableReader if the email has non-seekable readers that are not prepared (see Prepare).
+func (email Email) FinalHeaders() (textproto.MIMEHeader, error) {
+	if err := email.checkOneShot(); err != nil {
+		return nil, err
+	}
+	data, err := email.Clone().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := splitMessage(data)
+	return header, err
+}
+
+// splitMessage splits the composed email into the parsed headers and the body
+func splitMessage(data []byte) (textproto.MIMEHeader, []byte, error) {
+	end := bytes.Index(data, []byte(crlf+crlf))
+	if end < 0 {
+		return nil, nil, errors.New("Composed email has no body.")
+	}
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:end+4]))).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, data[end+4:], nil
+}
+
+// SetHeader sets the header entries associated with key to the single element value. It replaces any existing values associated with key.
+func (email *Email) SetHeader(key, value string) {
+	if email.Headers == nil {
+		email.Headers = make(textproto.MIMEHeader)
+	}
+	email.Headers.Set(key, value)
+	if indexOfHeader(email.headerOrder, key) < 0 {
+		email.headerOrder = append(email.headerOrder, key)
+	}
+}
+
+// RemoveHeader removes the header entries associated with key.
+// The key stays in the Headers map with no values to prevent Bytes() from adding its default value (e.g. Content-Language).
+func (email *Email) RemoveHeader(key string) {
+	if email.Headers == nil {
+		email.Headers = make(textproto.MIMEHeader)
+	}
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for k := range email.Headers {
+		if textproto.CanonicalMIMEHeaderKey(k) == key {
+			delete(email.Headers, k)
+		}
+	}
+	email.Headers[key] = []string{}
+	if i := indexOfHeader(email.headerOrder, key); i >= 0 {
+		email.headerOrder = append(email.headerOrder[:i], email.headerOrder[i+1:]...)
+	}
+}
+
+// SetListUnsubscribe sets the List-Unsubscribe header with the provided mailto address and/or HTTPS URL.
+// When the URL is provided the List-Unsubscribe-Post header is also set to enable one-click unsubscribe (RFC 8058).
+// At least one of the targets is required.
+func (email *Email) SetListUnsubscribe(mailto, httpURL string) error {
+	var targets []string
+	if len(mailto) > 0 {
+		if !strings.HasPrefix(strings.ToLower(mailto), "mailto:") {
+			mailto = "mailto:" + mailto
+		}
+		targets = append(targets, "<"+mailto+">")
+	}
+	if len(httpURL) > 0 {
+		targets = append(targets, "<"+httpURL+">")
+	}
+	if len(targets) == 0 {
+		return errors.New("At least one of the mailto and URL is required for List-Unsubscribe.")
+	}
+
+	email.SetHeader("List-Unsubscribe", strings.Join(targets, ", "))
+	if len(httpURL) > 0 {
+		email.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	return nil
+}
+
+// EnablePixelTracking adds the 1x1 tracking image to the HTMLBody right before the closing </body> tag or at the end of the body if there is no body tag.
+// Calling it again with the same URL will not add another image.
+func (email *Email) EnablePixelTracking(pixelURL string) {
+	pixel := `<img width="1" height="1" alt="" src="` + html.EscapeString(pixelURL) + `" />`
+	if strings.Contains(email.HTMLBody, pixel) {
+		return
+	}
+	if i := strings.LastIndex(strings.ToLower(email.HTMLBody), "</body>"); i >= 0 {
+		email.HTMLBody = email.HTMLBody[:i] + pixel + email.HTMLBody[i:]
+	} else {
+		email.HTMLBody += pixel
+	}
+}
+
+// EmbedImage adds the image as an inline attachment with a unique ContentID and returns the "cid:ID" reference for it.
+// If htmlPlaceholder is not blank, all its occurrences in the HTMLBody will be replaced with the returned reference.
+func (email *Email) EmbedImage(htmlPlaceholder string, data io.Reader, contentType string) string {
+	id := uuid.New().String()
+	name := id
+	if ext, _ := mime.ExtensionsByType(contentType); len(ext) > 0 {
+		name += ext[0]
+	}
+	email.Attachments = append(email.Attachments, Attachment{
+		Name:        name,
+		Data:        data,
+		ContentID:   id,
+		ContentType: contentType,
+		Inline:      true,
+	})
+
+	cid := "cid:" + id
+	if len(htmlPlaceholder) > 0 {
+		email.HTMLBody = strings.ReplaceAll(email.HTMLBody, htmlPlaceholder, cid)
+	}
+	return cid
+}
+
+// AttachReader adds the attachment read from r. When contentType is blank it is derived from the name extension.
+// Nil readers (including typed nil pointers such as a nil *bytes.Buffer) are attached without the Data,
+// so Validate() and Bytes() return ErrAttachmentMissingData instead of sending an empty file.
+func (email *Email) AttachReader(name string, r io.Reader, contentType string) {
+	if isNilReader(r) {
+		r = nil
+	}
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	email.Attachments = append(email.Attachments, Attachment{
+		Name:        name,
+		Data:        r,
+		ContentType: contentType,
+	})
+}
+
+// AttachBytes adds the attachment with the data. When contentType is blank it is derived from the name extension.
+func (email *Email) AttachBytes(name string, data []byte, contentType string) {
+	email.AttachReader(name, bytes.NewReader(data), contentType)
+}
+
+// isNilReader returns true if the reader is a typed nil pointer (e.g. (*bytes.Buffer)(nil))
+func isNilReader(r io.Reader) bool {
+	v := reflect.ValueOf(r)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// NewAttachmentFromFS creates an attachment from the named file of the file system (e.g. embed.FS).
+// The file is read into memory and the ContentType is derived from the file extension.
+func NewAttachmentFromFS(fsys fs.FS, name string) (Attachment, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Attachment{}, err
+	}
+	return Attachment{
+		Name:        path.Base(name),
+		Data:        bytes.NewReader(data),
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+	}, nil
+}
+
+// NewAttachmentFromURL creates an attachment from the content of the URL (e.g. S3 presigned URL) using the client (http.DefaultClient when nil).
+// The response body is streamed as the attachment Data and closed once it is read to the end, so the email can be composed
+// only once unless Email.Prepare() is called. The ContentType is taken from the response Content-Type header and the Name
+// from the Content-Disposition filename or the last URL path segment.
+func NewAttachmentFromURL(ctx context.Context, client *http.Client, rawURL string) (Attachment, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Attachment{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return Attachment{}, fmt.Errorf("Failed to download the attachment from %s: %s", req.URL.Redacted(), resp.Status)
+	}
+
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if base := path.Base(req.URL.Path); len(name) == 0 && base != "/" && base != "." {
+		name = base
+	}
+	return Attachment{
+		Name:        name,
+		Data:        &closingReader{resp.Body},
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// closingReader closes the underlying reader when it returns an error (including io.EOF)
+type closingReader struct {
+	io.ReadCloser
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.ReadCloser.Close()
+	}
+	return n, err
+}
+
+// dataURIPattern matches the src attributes with the data URI values (e.g. src="data:image/png;base64,...")
+var dataURIPattern = regexp.MustCompile(`(?i)\bsrc\s*=\s*("data:[^"]*"|'data:[^']*')`)
+
+// InlineDataURIs moves the base64 data URI images (e.g. <img src="data:image/png;base64,...">) from the HTMLBody
+// into the inline attachments and replaces the src values with the "cid:ID" references.
+// Data URIs that are not base64 encoded are left unchanged.
+func (email *Email) InlineDataURIs() error {
+	var err error
+	email.HTMLBody = dataURIPattern.ReplaceAllStringFunc(email.HTMLBody, func(match string) string {
+		if err != nil {
+			return match
+		}
+		uri := dataURIPattern.FindStringSubmatch(match)[1]
+		uri = html.UnescapeString(uri[1 : len(uri)-1])
+		i := strings.Index(strings.ToLower(uri), ";base64,")
+		if i < 0 {
+			return match
+		}
+		contentType := uri[len("data:"):i]
+		if j := strings.IndexByte(contentType, ';'); j >= 0 {
+			contentType = contentType[:j]
+		}
+		data, errD := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(uri[i+len(";base64,"):]), ""))
+		if errD != nil {
+			err = fmt.Errorf("Invalid base64 data URI image: %v", errD)
+			return match
+		}
+		return `src="` + email.EmbedImage("", bytes.NewReader(data), contentType) + `"`
+	})
+	return err
+}
+
+func addPart(writer *multipart.Writer, contentType string, body string, fileName string, encoding string) error {
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", bodyTransferEncoding(body, encoding))
+	if disposition := bodyDisposition(fileName); len(disposition) > 0 {
+		h.Set("Content-Disposition", disposition)
+	}
+	part, err := createPart(writer, h)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, encodeBody(body, encoding))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// bodyDisposition returns the inline Content-Disposition with the file name of the body part or blank string if the name is blank
+func bodyDisposition(fileName string) string {
+	fileName = strings.TrimSpace(fileName)
+	if len(fileName) == 0 {
+		return ""
+	}
+	return "inline; " + filenameParams(filepath.Base(fileName))
+}
+
+// hasData returns true if the attachment Data reader is set
+func (item Attachment) hasData() bool {
+	return !(item.Data == nil || item.Data == (*bytes.Buffer)(nil) || item.Data == (*os.File)(nil))
+}
+
+// isOneShot returns true if the attachment Data reader can be read only once (non-seekable reader)
+func (item Attachment) isOneShot() bool {
+	if !item.hasData() {
+		return false
+	}
+	_, ok := item.Data.(io.Seeker)
+	return !ok
+}
+
+// contentID returns the ContentID without the surrounding angle brackets
+func (item Attachment) contentID() string {
+	id := strings.TrimSpace(item.ContentID)
+	if strings.HasPrefix(id, "<") && strings.HasSuffix(id, ">") {
+		id = id[1 : len(id)-1]
+	}
+	return id
+}
+
+// size returns the size of the attachment data when it is known without reading the data (file size or size of the in-memory reader)
+func (item Attachment) size() (int64, error) {
+	if item.hasData() {
+		switch r := item.Data.(type) {
+		case interface{ Size() int64 }: // *bytes.Reader, *strings.Reader
+			return r.Size(), nil
+		case *bytes.Buffer:
+			return int64(r.Len()), nil
+		case *os.File:
+			fi, err := r.Stat()
+			if err != nil {
+				return 0, err
+			}
+			return fi.Size(), nil
+		}
+		return 0, nil
+	}
+	if len(item.FileName) > 0 {
+		fi, err := os.Stat(item.FileName)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	return 0, ErrAttachmentMissingData
+}
+
+// fileName returns the base of the attachment Name or, when the Name is blank, the base of the ContentType name parameter
+// or the FileName. If none is set the generated "attachment-N" name is returned (N is the 1-based position of the attachment).
+func (item Attachment) fileName(index int) string {
+	_, params, _ := mime.ParseMediaType(item.ContentType)
+	for _, name := range []string{item.Name, params["name"], item.FileName} {
+		if base := filepath.Base(strings.TrimSpace(name)); len(strings.TrimSpace(name)) > 0 && base != "." && base != string(filepath.Separator) {
+			return base
+		}
+	}
+	return fmt.Sprintf("attachment-%d", index+1)
+}
+
+// reusableReader is the Data of the reusable attachment (see Attachment.Reusable). The data is read with a fresh reader
+// every time the email is composed, so the attachment can be shared by many emails (including the emails sent concurrently).
+type reusableReader struct {
+	*bytes.Reader
+	data []byte
+}
+
+// Reusable returns the copy of the attachment backed by the in-memory data that is read with a fresh reader every time
+// the email is composed, so the same attachment can be attached to many emails (e.g. sent in a loop or with SendConcurrent).
+// The Data reader (or the FileName file when the Data is not set) is read once.
+func (item Attachment) Reusable() (Attachment, error) {
+	var data []byte
+	var err error
+	switch {
+	case item.hasData():
+		if _, ok := item.Data.(*reusableReader); ok {
+			return item, nil
+		}
+		if seeker, ok := item.Data.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return item, err
+			}
+		}
+		data, err = io.ReadAll(item.Data)
+	case len(item.FileName) > 0:
+		data, err = os.ReadFile(item.FileName)
+	default:
+		return item, ErrAttachmentMissingData
+	}
+	if err != nil {
+		return item, err
+	}
+	item.Data = &reusableReader{Reader: bytes.NewReader(data), data: data}
+	return item, nil
+}
+
+// Prepare reads the attachments with non-seekable Data readers (e.g. *bytes.Buffer) and the HTML body set with
+// a non-seekable SetHTMLBodyReader into memory so the email can be composed more than once
+// (e.g. calling Bytes() twice or resending the email).
+// Seekable readers (e.g. *os.File, *bytes.Reader) are rewound before each use and don't need to be prepared.
+func (email *Email) Prepare() error {
+	if email.htmlBodyOneShot {
+		if err := email.renderHTMLBody(); err != nil {
+			return err
+		}
+	}
+	for i, item := range email.Attachments {
+		if !item.hasData() {
+			continue
+		}
+		if _, ok := item.Data.(io.Seeker); ok {
+			continue
+		}
+		data, err := io.ReadAll(item.Data)
+		if err != nil {
+			return err
+		}
+		email.Attachments[i].Data = bytes.NewReader(data)
+	}
+	return nil
+}
+
+// _addAttachment writes the attachment as the base64 encoded part of the multipart writer
+func _addAttachment(writer *multipart.Writer, item Attachment) error {
+	contentType := item.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	// add name parameter for older clients that don't read the filename from Content-Disposition
+	// and keep it in agreement with the Content-Disposition filename
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && len(item.Name) > 0 {
+		name := filepath.Base(item.Name)
+		if value, ok := params["name"]; !ok {
+			contentType += "; " + mimeParam("name", name)
+		} else if value != name {
+			logf("raweml: attachment Content-Type name %q doesn't match the attachment name %q, using %q", value, name, name)
+			params["name"] = name
+			contentType = formatMediaType(mediaType, params)
+		}
+	}
+	fileReader := item.Data
+
+	if item.hasData() {
+		// reusable attachments are read with a fresh reader and seekable readers are rewound
+		// so the attachment can be written more than once
+		if r, ok := fileReader.(*reusableReader); ok {
+			fileReader = bytes.NewReader(r.data)
+		} else if seeker, ok := fileReader.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	} else {
+		if len(item.FileName) > 0 {
+			file, err := os.Open(item.FileName)
+			if err != nil {
+				return err
+				// alternative: attach blank file
+				// fmt.Fprintf(w, "\n--%s\n", boundary)
+				// fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\n")
+				// fmt.Fprintf(w, "could not open file: %v\n", err)
+			}
+			fileReader = file
+			defer file.Close()
+		} else {
+			return ErrAttachmentMissingData
+		}
+	}
+
+	disposition := "attachment"
+	if item.Inline {
+		disposition = "inline"
+	}
+	// the keys are assigned directly to keep the "Content-ID" casing
+	h := textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-ID":                {"<" + item.contentID() + ">"},
+		"X-Attachment-Id":           {item.contentID()},
+		"Content-Disposition":       {disposition + "; " + filenameParams(filepath.Base(item.Name))},
+	}
+	if len(item.Description) > 0 {
+		h["Content-Description"] = []string{mime.QEncoding.Encode("utf-8", item.Description)}
+	}
+	part, err := createPart(writer, h)
+	if err != nil {
+		return err
+	}
+
+	b64 := base64.NewEncoder(base64.StdEncoding, part)
+	if _, err := io.Copy(b64, fileReader); err != nil {
+		return err
+	}
+	if err := b64.Close(); err != nil {
+		return err
+	}
+
+	// compress
+	// gzip := gzip.NewWriter(b64)
+	// defer gzip.Close()
+	// io.Copy(gzip, file)
+
+	return nil
+}
+
+// orderedAttachments returns the attachments with their resolved names in the order they are emitted
+func (email Email) orderedAttachments() []Attachment {
+	attachments := make([]Attachment, len(email.Attachments))
+	for i, item := range email.Attachments {
+		item.Name = item.fileName(i)
+		attachments[i] = item
+	}
+	if email.SortAttachments {
+		sort.SliceStable(attachments, func(i, j int) bool { return attachments[i].Name < attachments[j].Name })
+	}
+	return attachments
+}
+
+// addAttachments writes the attachments in the order of the slice as the parts of the multipart writer
+func addAttachments(writer *multipart.Writer, attachments []Attachment) error {
+	for i, item := range attachments {
+		item.Name = item.fileName(i)
+		if err := _addAttachment(writer, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the specified MIMEHeader to the io.Writer.
+// Header values will be trimmed but otherwise left alone.
+// Headers with multiple values are not supported and will return an error.
+// Headers listed in `ordered` are written last, in the given order and casing.
+func writeHeader(w io.Writer, header *textproto.MIMEHeader, ordered []string) error {
+	// for k, vs := range *header {
+	for _, k := range orderedHeaders(header, ordered) {
+		vs, ok := (*header)[k]
+		if !ok {
+			vs = (*header)[textproto.CanonicalMIMEHeaderKey(k)]
+		}
+		if len(vs) == 0 {
+			continue // removed header
+		}
+		if err := checkHeader(k, vs); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "%s: ", k)
+		if err != nil {
+			return err
+		}
+
+		for i, v := range vs {
 			v = textproto.TrimString(v)
 
 			_, err := fmt.Fprintf(w, "%s", v)
@@ -421,7 +1776,7 @@ func writeHeader(w io.Writer, header *textproto.MIMEHeader) error {
 			}
 
 			if i < len(vs)-1 {
-				return errors.New("Multiple header values are not supported.")
+				return ErrMultipleHeaderValues
 			}
 		}
 
@@ -439,12 +1794,103 @@ func writeHeader(w io.Writer, header *textproto.MIMEHeader) error {
 
 	return nil
 }
+
+// checkHeader returns an error if the header key or values contain CR, LF or NUL characters that could inject other headers.
+// Folded values (CRLF followed by a space or tab) are allowed.
+func checkHeader(key string, values []string) error {
+	if strings.ContainsAny(key, "\r\n\x00: ") {
+		return fmt.Errorf("%w: header name %q", ErrInvalidHeader, key)
+	}
+	for _, v := range values {
+		for i := 0; i < len(v); i++ {
+			switch v[i] {
+			case '\r':
+				if i+2 < len(v) && v[i+1] == '\n' && (v[i+2] == ' ' || v[i+2] == '\t') {
+					i += 2
+					continue
+				}
+				return fmt.Errorf("%w: %s header value %q", ErrInvalidHeader, key, v)
+			case '\n', 0:
+				return fmt.Errorf("%w: %s header value %q", ErrInvalidHeader, key, v)
+			}
+		}
+	}
+	return nil
+}
+
+// createPart checks the part headers (e.g. the attachment name or content type with CR/LF) and creates the part
+func createPart(writer *multipart.Writer, h textproto.MIMEHeader) (io.Writer, error) {
+	for key, values := range h {
+		if err := checkHeader(key, values); err != nil {
+			return nil, err
+		}
+	}
+	return writer.CreatePart(h)
+}
+
 func setIfMissing(h *textproto.MIMEHeader, key, value string) {
-	if len(value) > 0 {
-		if _, ok := (*h)[key]; h != nil && !ok {
-			h.Set(key, value)
+	if len(value) > 0 && h != nil && !hasHeader(h, key) {
+		h.Set(key, value)
+	}
+}
+
+// hasHeader checks if the header key exists regardless of its casing (e.g. "content-type" set directly in the Headers map)
+func hasHeader(h *textproto.MIMEHeader, key string) bool {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	if _, ok := (*h)[key]; ok {
+		return true
+	}
+	for k := range *h {
+		if textproto.CanonicalMIMEHeaderKey(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHeaderValue returns true if the header with the key in any casing has a non-blank value
+func hasHeaderValue(h textproto.MIMEHeader, key string) bool {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for k, vs := range h {
+		if textproto.CanonicalMIMEHeaderKey(k) != key {
+			continue
+		}
+		for _, v := range vs {
+			if len(strings.TrimSpace(v)) > 0 {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// takeHeader removes the header with the key in any casing (e.g. "content-type") and returns its first value
+func takeHeader(h *textproto.MIMEHeader, key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	value := ""
+	for k, vs := range *h {
+		if textproto.CanonicalMIMEHeaderKey(k) == key {
+			if len(vs) > 0 && (len(value) == 0 || k == key) {
+				value = vs[0]
+			}
+			delete(*h, k)
+		}
+	}
+	return value
+}
+
+// multipartContentType returns the multipart Content-Type of the composed body with the boundary merged with the parameters
+// of the user supplied multipart Content-Type (e.g. type="text/html"). The media type of the composed body is kept
+// because the user media type (e.g. multipart/related) doesn't match the structure of the body.
+func multipartContentType(userValue, mediaType, boundary string) string {
+	userType, params, err := mime.ParseMediaType(userValue)
+	if err != nil || !strings.HasPrefix(userType, "multipart/") {
+		params = nil
+	} else if userType != mediaType {
+		logf("raweml: Content-Type %q doesn't match the composed body, using %q with the Content-Type parameters", userType, mediaType)
+	}
+	delete(params, "boundary")
+	return formatMediaType(mediaType+"; boundary=\""+boundary+"\"", params)
 }
 
 // -- Helpter functions -------------------------------------------
@@ -457,6 +1903,216 @@ func (email Email) GetSource() *string {
 	return nil
 }
 
+// GetSourceChecked returns the From email address after validating it.
+// If the From field contains multiple addresses only the first one is returned.
+func (email Email) GetSourceChecked() (*string, error) {
+	if len(email.From) == 0 {
+		return nil, nil
+	}
+	addresses, err := mail.ParseAddressList(email.From)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid From address %q: %v", email.From, err)
+	}
+	return aws.String(formatAddress(addresses[0])), nil
+}
+
+// getFrom returns the From addresses in the canonical "Name <address>, ..." format
+func (email Email) getFrom() string {
+	if from, err := formatAddressList(email.From); err == nil {
+		return from
+	}
+	return email.From
+}
+
+// getSender returns the first From address if the From has multiple addresses (RFC 5322 section 3.6.2 requires the Sender then),
+// otherwise returns blank string
+func (email Email) getSender() string {
+	if addresses, err := mail.ParseAddressList(email.From); err == nil && len(addresses) > 1 {
+		return formatAddress(addresses[0])
+	}
+	return ""
+}
+
+// getFeedback returns the envelope sender or the feedback address or the first From address if both fields are blank
+func (email Email) getFeedback() string {
+	if len(email.EnvelopeFrom) > 0 {
+		return email.EnvelopeFrom
+	}
+	if len(email.Feedback) > 0 {
+		return email.Feedback
+	}
+	if email.Minimal {
+		return ""
+	}
+	if source, err := email.GetSourceChecked(); err == nil && source != nil {
+		return *source
+	}
+	return ""
+}
+
+// formatAddressList parses the comma separated list of addresses and returns it in the canonical "Name <address>, ..." format
+func formatAddressList(list string) (string, error) {
+	addresses, err := mail.ParseAddressList(list)
+	if err != nil {
+		return "", err
+	}
+	var r []string
+	for _, a := range addresses {
+		r = append(r, formatAddress(a))
+	}
+	return strings.Join(r, ", "), nil
+}
+
+// formatAddress returns the address as "Name <address>". The name is quoted or MIME encoded only when required.
+func formatAddress(a *mail.Address) string {
+	if len(a.Name) == 0 {
+		return a.Address
+	}
+	if !isASCII(a.Name) || strings.ContainsAny(a.Name, "()<>[]:;@\\,.\"") {
+		return a.String()
+	}
+	return a.Name + " <" + a.Address + ">"
+}
+
+// maxEncodedWordLen is the maximum length of a RFC 2047 encoded-word
+const maxEncodedWordLen = 75
+
+// encodeHeaderValue returns the header value as RFC 2047 "Q" encoded-words folded with CRLF+space if it contains non-ASCII characters.
+// Each encoded-word is at most 75 characters long and multibyte characters are never split between the encoded-words.
+func encodeHeaderValue(value string) string {
+	if isASCII(value) {
+		return value
+	}
+	const prefix, suffix = "=?utf-8?q?", "?="
+	var words []string
+	word := new(strings.Builder)
+	for _, r := range value {
+		encoded := qEncodeRune(r)
+		if len(prefix)+word.Len()+len(encoded)+len(suffix) > maxEncodedWordLen {
+			words = append(words, prefix+word.String()+suffix)
+			word.Reset()
+		}
+		word.WriteString(encoded)
+	}
+	words = append(words, prefix+word.String()+suffix)
+	return strings.Join(words, crlf+" ")
+}
+
+// qEncodeRune returns the rune encoded for the RFC 2047 "Q" encoded-word used in the headers
+func qEncodeRune(r rune) string {
+	if r == ' ' {
+		return "_"
+	}
+	if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!*+-/", r)) {
+		return string(r)
+	}
+	buf := make([]byte, utf8.UTFMax)
+	n := utf8.EncodeRune(buf, r)
+	encoded := new(strings.Builder)
+	for _, b := range buf[:n] {
+		fmt.Fprintf(encoded, "=%02X", b)
+	}
+	return encoded.String()
+}
+
+// mimeParam returns the header parameter as key="value" or RFC 2231 encoded key*=utf-8”value if the value contains non-ASCII characters
+func mimeParam(key, value string) string {
+	if isASCII(value) {
+		return key + "=\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(value) + "\""
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 128 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return key + "*=utf-8''" + b.String()
+}
+
+// filenameParams returns the filename parameter for the Content-Disposition header.
+// Non-ASCII names are written twice: as ASCII filename="..." for older clients and as RFC 2231 encoded filename*=utf-8”... for newer clients.
+func filenameParams(name string) string {
+	if isASCII(name) {
+		return mimeParam("filename", name)
+	}
+	fallback := strings.Map(func(r rune) rune {
+		if r > 127 {
+			return '_'
+		}
+		return r
+	}, name)
+	return mimeParam("filename", fallback) + "; " + mimeParam("filename", name)
+}
+
+// bodyTransferEncoding returns the Content-Transfer-Encoding of the body for the Email.BodyEncoding
+func bodyTransferEncoding(body, encoding string) string {
+	if encoding == "base64" {
+		return encoding
+	}
+	return transferEncoding(body)
+}
+
+// encodeBody returns the body with the CRLF line endings encoded with the Email.BodyEncoding.
+// The base64 encoded body is wrapped to 76 characters per line.
+func encodeBody(body, encoding string) string {
+	body = canonicalLineEndings(body)
+	if encoding != "base64" {
+		return body
+	}
+	return base64Lines([]byte(body))
+}
+
+// base64Lines returns the base64 encoded data wrapped to 76 characters per line (RFC 2045)
+func base64Lines(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	b := new(strings.Builder)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + crlf)
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	return b.String()
+}
+
+// transferEncoding returns "8bit" if the body contains non-ASCII bytes, otherwise returns "7bit"
+func transferEncoding(body string) string {
+	if isASCII(body) {
+		return "7bit"
+	}
+	return "8bit"
+}
+
+// formatMediaType returns the media type with the parameters sorted by their names
+func formatMediaType(mediaType string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		mediaType += "; " + mimeParam(k, params[k])
+	}
+	return mediaType
+}
+
+// canonicalLineEndings converts the bare LF and CR line endings to CRLF
+func canonicalLineEndings(s string) string {
+	return strings.NewReplacer(crlf, crlf, "\r", crlf, "\n", crlf).Replace(s)
+}
+
+// isASCII returns true if the string does not contain any bytes > 127
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 127 {
+			return false
+		}
+	}
+	return true
+}
+
 func (email Email) getCharSet() string {
 	if len(email.CharSet) > 0 {
 		return email.CharSet
@@ -464,6 +2120,55 @@ func (email Email) getCharSet() string {
 	return "UTF-8"
 }
 
+func (email Email) getLanguage() string {
+	if email.Language != nil {
+		return *email.Language
+	}
+	if email.Minimal {
+		return ""
+	}
+	return "en-US"
+}
+
+// PriorityFromString converts case-insensitive "high", "normal", "low" or numeric "1", "3", "5" value to email priority
+func PriorityFromString(s string) (EmailPriority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high", "1":
+		return PriorityHigh, nil
+	case "normal", "3":
+		return PriorityNormal, nil
+	case "low", "5":
+		return PriorityLow, nil
+	default:
+		return "", fmt.Errorf("Invalid email priority %q. Expected one of: high, normal, low.", s)
+	}
+}
+
+func (email Email) getMailer() string {
+	if len(email.Mailer) > 0 || email.Minimal {
+		return email.Mailer
+	}
+	return "raweml/" + Version
+}
+
+// getTextCharSet returns the charset of the text body. Pure ASCII text body is labeled "us-ascii" unless the charset is set explicitly.
+func (email Email) getTextCharSet() string {
+	if len(email.TextCharSet) > 0 {
+		return email.TextCharSet
+	}
+	if len(email.CharSet) == 0 && isASCII(email.TextBody) {
+		return "us-ascii"
+	}
+	return email.getCharSet()
+}
+
+func (email Email) getHTMLCharSet() string {
+	if len(email.HTMLCharSet) > 0 {
+		return email.HTMLCharSet
+	}
+	return email.getCharSet()
+}
+
 // ToNumber converts email priority to a string number
 func (priority EmailPriority) ToNumber() string {
 	switch priority {
@@ -483,6 +2188,43 @@ func (priority EmailPriority) String() string {
 	return string(priority)
 }
 
+// duplicateContentIDs returns the non-empty ContentID values that are used by more than one attachment
+func duplicateContentIDs(attachments []Attachment) (dup []string) {
+	seen := make(map[string]int)
+	for _, item := range attachments {
+		id := item.contentID()
+		if len(id) == 0 {
+			continue
+		}
+		seen[id]++
+		if seen[id] == 2 {
+			dup = append(dup, id)
+		}
+	}
+	return dup
+}
+
+// orderedHeaders returns sorted header keys followed by the `ordered` keys
+func orderedHeaders(header *textproto.MIMEHeader, ordered []string) (keys []string) {
+	for _, k := range sortedHeaders(header) {
+		if indexOfHeader(ordered, k) < 0 {
+			keys = append(keys, k)
+		}
+	}
+	return append(keys, ordered...)
+}
+
+// indexOfHeader returns the index of the header key in keys regardless of its casing or -1 if not found
+func indexOfHeader(keys []string, key string) int {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for i, k := range keys {
+		if textproto.CanonicalMIMEHeaderKey(k) == key {
+			return i
+		}
+	}
+	return -1
+}
+
 func sortedHeaders(header *textproto.MIMEHeader) (keys []string) {
 	// type MIMEHeader map[string][]string
 	for k := range *header {
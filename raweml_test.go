@@ -1,8 +1,33 @@
 package raweml
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"github.com/google/uuid"
 )
 
 // ---------------------------------------------------------------
@@ -15,12 +40,14 @@ var (
 Content-Type: multipart/mixed; boundary=*
 From: NO REPLAY EMAIL ACCOUNT <no-reply@example.com>
 Mime-Version: 1.0
-References: MbfJRQw5X+qg8GSOJxjM2Q==
+Return-Path: NO REPLAY EMAIL ACCOUNT <no-reply@example.com>
 Subject: Simple Test
 Thread-Index: *
 Thread-Topic: Hello world
 To: customer@example.com
+X-Mailer: raweml/` + Version + `
 X-Priority: 3
+X-Something: test
 
 *
 Content-Type: multipart/alternative; boundary=*
@@ -28,7 +55,7 @@ Mime-Version: 1.0
 
 *
 Content-Transfer-Encoding: 7bit
-Content-Type: text/plain; charset=UTF-8
+Content-Type: text/plain; charset=us-ascii
 
 Amazon SES Test Email (AWS SDK for Go)
 *
@@ -39,11 +66,11 @@ Content-Type: text/html; charset=UTF-8
 *
 *
 *
-Content-Type: application/octet-stream
-Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="Mars.png"
 Content-ID: <1001>
+Content-Transfer-Encoding: base64
+Content-Type: application/octet-stream; name="Mars.png"
 X-Attachment-Id: 1001
-Content-Disposition: attachment; filename="Mars.png"
 
 *`
 )
@@ -117,4 +144,2313 @@ func TestRaweml(t *testing.T) {
 			t.Errorf("Invalid Recipients!\nwant:%s\ngot:%s", want, got)
 		}
 	})
-}
+	t.Run("Test attachment Content-Description", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "Attachment description",
+			TextBody:    "See attached.",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png", ContentID: "1001", Description: "Picture of Mars"}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Description: Picture of Mars\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Missing Content-Description header!\nwant:%s\ngot:%s", want, data)
+		}
+
+		// non-ASCII description must be MIME encoded
+		eml.Attachments[0].Description = "Марс"
+		data, err = eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Description: =?utf-8?q?"; !strings.Contains(string(data), want) {
+			t.Errorf("Content-Description is not MIME encoded!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test duplicate attachment ContentID", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Duplicate ContentID",
+			HTMLBody:   "<img src='cid:1001'/>",
+			Attachments: []Attachment{
+				{Name: "Mars.png", FileName: "example/Mars.png", ContentID: "1001"},
+				{Name: "Mars2.png", FileName: "example/Mars.png", ContentID: "1001"},
+			},
+		}
+		if err := eml.Validate(); err == nil || !strings.Contains(err.Error(), "1001") {
+			t.Errorf("Expected duplicate ContentID error naming 1001!\ngot:%v", err)
+		}
+		if _, err := eml.Bytes(); err == nil {
+			t.Error("Expected Bytes() to fail on duplicate ContentID!")
+		}
+	})
+	t.Run("Test embedding images", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Embedded images",
+			HTMLBody:   "<img src='{{mars}}'/><img src='{{venus}}'/>",
+		}
+		cid1 := eml.EmbedImage("{{mars}}", strings.NewReader("mars"), "image/png")
+		cid2 := eml.EmbedImage("{{venus}}", strings.NewReader("venus"), "image/png")
+		if cid1 == cid2 || !strings.HasPrefix(cid1, "cid:") || !strings.HasPrefix(cid2, "cid:") {
+			t.Fatalf("Invalid ContentIDs!\ngot:%s and %s", cid1, cid2)
+		}
+		if want := "<img src='" + cid1 + "'/><img src='" + cid2 + "'/>"; eml.HTMLBody != want {
+			t.Errorf("Invalid HTML body!\nwant:%s\ngot:%s", want, eml.HTMLBody)
+		}
+
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cid := range []string{cid1, cid2} {
+			if want := "Content-ID: <" + strings.TrimPrefix(cid, "cid:") + ">"; !strings.Contains(string(data), want) {
+				t.Errorf("Missing inline part!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+		if got := strings.Count(string(data), "Content-Disposition: inline;"); got != 2 {
+			t.Errorf("Invalid number of inline parts!\nwant:%v\ngot:%v", 2, got)
+		}
+	})
+	t.Run("Test List-Unsubscribe", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Newsletter",
+			TextBody:   "Hello",
+		}
+		if err := eml.SetListUnsubscribe("", ""); err == nil {
+			t.Error("Expected error when no unsubscribe target is provided!")
+		}
+		if err := eml.SetListUnsubscribe("unsubscribe@example.com", "https://example.com/unsubscribe?id=1"); err != nil {
+			t.Fatal(err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"List-Unsubscribe: <mailto:unsubscribe@example.com>, <https://example.com/unsubscribe?id=1>\r\n",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n",
+		} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test Auto-Submitted header", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Password reset",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Auto-Submitted:") {
+			t.Errorf("Auto-Submitted header should not be set by default!\ngot:%s", data)
+		}
+
+		eml.AutoSubmitted = "auto-generated"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Auto-Submitted: auto-generated\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Missing Auto-Submitted header!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test lowercase user header is not overwritten", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Lowercase header",
+			TextBody:   "Hello",
+			Headers:    textproto.MIMEHeader{"from": {"Support <support@example.com>"}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "from: Support <support@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("User header is missing!\nwant:%s\ngot:%s", want, data)
+		}
+		if strings.Contains(string(data), "From: no-reply@example.com") {
+			t.Errorf("User header was overwritten!\ngot:%s", data)
+		}
+	})
+	t.Run("Test removing default header", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Remove header",
+			TextBody:   "Hello",
+		}
+		eml.RemoveHeader("content-language")
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Content-Language") {
+			t.Errorf("Removed header is present!\ngot:%s", data)
+		}
+	})
+	t.Run("Test Content-Language", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Bonjour",
+			TextBody:   "Bonjour",
+			Language:   aws.String("fr-FR"),
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Language: fr-FR\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Content-Language!\nwant:%s\ngot:%s", want, data)
+		}
+
+		// blank language omits the header
+		eml.Language = aws.String("")
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Content-Language") {
+			t.Errorf("Content-Language should be omitted!\ngot:%s", data)
+		}
+	})
+	t.Run("Test keeping custom header order", func(t *testing.T) {
+		eml := Email{
+			From:            "no-reply@example.com",
+			Recipients:      NewRecipients("customer@example.com", "", ""),
+			Subject:         "Header order",
+			TextBody:        "Hello",
+			KeepHeaderOrder: true,
+		}
+		eml.SetHeader("X-MyApp-TraceID", "1")
+		eml.SetHeader("X-B", "2")
+		eml.SetHeader("X-A", "3")
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "Content-Language: en-US\r\n" +
+			"Content-Transfer-Encoding: 7bit\r\n" +
+			"Content-Type: text/plain; charset=us-ascii\r\n" +
+			"From: no-reply@example.com\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"Return-Path: no-reply@example.com\r\n" +
+			"Subject: Header order\r\n" +
+			"To: customer@example.com\r\n" +
+			"X-Mailer: raweml/" + Version + "\r\n" +
+			"X-Priority: 3\r\n" +
+			"X-MyApp-TraceID: 1\r\n" +
+			"X-B: 2\r\n" +
+			"X-A: 3\r\n\r\n"
+		if !strings.HasPrefix(string(data), want) {
+			t.Errorf("Invalid header order!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test priority headers", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Urgent",
+			TextBody:   "Hello",
+			Priority:   PriorityHigh,
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"Importance: High\r\n", "X-Priority: 1\r\n", "X-Msmail-Priority: High\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing priority header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test priority from string", func(t *testing.T) {
+		valid := map[string]EmailPriority{
+			"high":   PriorityHigh,
+			"HIGH":   PriorityHigh,
+			"1":      PriorityHigh,
+			"Normal": PriorityNormal,
+			"3":      PriorityNormal,
+			" low ":  PriorityLow,
+			"5":      PriorityLow,
+		}
+		for s, want := range valid {
+			got, err := PriorityFromString(s)
+			if err != nil || got != want {
+				t.Errorf("Invalid priority for %q!\nwant:%v\ngot:%v (%v)", s, want, got, err)
+			}
+		}
+		for _, s := range []string{"", "urgent", "2"} {
+			if _, err := PriorityFromString(s); err == nil {
+				t.Errorf("Expected error for priority %q!", s)
+			}
+		}
+	})
+	t.Run("Test invalid From address", func(t *testing.T) {
+		eml := Email{
+			From:       "not an address",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Invalid From",
+			TextBody:   "Hello",
+		}
+		if _, err := eml.GetSourceChecked(); err == nil {
+			t.Error("Expected GetSourceChecked error for invalid From address!")
+		}
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected Validate error for invalid From address!")
+		}
+
+		eml.From = "Support <support@example.com>"
+		if got, err := eml.GetSourceChecked(); err != nil || *got != eml.From {
+			t.Errorf("Invalid source!\nwant:%s\ngot:%v (%v)", eml.From, got, err)
+		}
+	})
+	t.Run("Test multiple From addresses", func(t *testing.T) {
+		eml := Email{
+			From:       "Support <support@example.com>,sales@example.com , \"Doe, John\" <john@example.com>",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Multiple From",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "From: Support <support@example.com>, sales@example.com, \"Doe, John\" <john@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid From header!\nwant:%s\ngot:%s", want, data)
+		}
+		if got, err := eml.GetSourceChecked(); err != nil || *got != "Support <support@example.com>" {
+			t.Errorf("Invalid source!\nwant:%s\ngot:%v (%v)", "Support <support@example.com>", got, err)
+		}
+		if want := "Sender: Support <support@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Sender is required for multiple From addresses!\nwant:%s\ngot:%s", want, data)
+		}
+		eml.SetHeader("Sender", "assistant@example.com")
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Sender: assistant@example.com\r\n"; !strings.Contains(string(data), want) || strings.Count(string(data), "Sender:") != 1 {
+			t.Errorf("Explicit Sender should be kept!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.From = "support@example.com, not an address"
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected error for invalid From address list!")
+		}
+	})
+	t.Run("Test Return-Path", func(t *testing.T) {
+		eml := Email{
+			From:       "Support <support@example.com>, sales@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Return-Path",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Return-Path: Support <support@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Return-Path should default to From!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.Feedback = "feedback@example.com"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Return-Path: feedback@example.com\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Return-Path!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.Feedback = "not an address"
+		if _, err := eml.Bytes(); err == nil {
+			t.Error("Expected error for invalid Feedback address!")
+		}
+	})
+	t.Run("Test Content-Transfer-Encoding", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Encoding",
+			TextBody:   "Hello",
+			HTMLBody:   "<p>Здраво</p>",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"Content-Transfer-Encoding: 7bit\r\nContent-Type: text/plain; charset=us-ascii\r\n",
+			"Content-Transfer-Encoding: 8bit\r\nContent-Type: text/html; charset=UTF-8\r\n",
+		} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Invalid Content-Transfer-Encoding!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+
+		// single part body
+		eml.TextBody = ""
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Transfer-Encoding: 8bit\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Content-Transfer-Encoding!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test SendTo", func(t *testing.T) {
+		mock := useMockSES(t)
+		if err := SendTo("us-east-1", "no-reply@example.com", "customer@example.com", "Hello", "<h1>Hello</h1>"); err != nil {
+			t.Fatal(err)
+		}
+		if len(mock.inputs) != 1 {
+			t.Fatalf("Invalid number of sent emails!\nwant:%v\ngot:%v", 1, len(mock.inputs))
+		}
+		input := mock.inputs[0]
+		if len(input.Destinations) != 1 || *input.Destinations[0] != "customer@example.com" {
+			t.Errorf("Invalid destinations!\nwant:%v\ngot:%v", "customer@example.com", toStringArray(input.Destinations))
+		}
+		if want := "Subject: Hello\r\n"; !strings.Contains(string(input.RawMessage.Data), want) {
+			t.Errorf("Invalid subject!\nwant:%s\ngot:%s", want, input.RawMessage.Data)
+		}
+	})
+	t.Run("Test composing email twice", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Twice",
+			TextBody:   "Hello",
+			Attachments: []Attachment{
+				{Name: "a.txt", Data: bytes.NewBufferString("buffer content")},
+				{Name: "b.txt", Data: strings.NewReader("reader content")},
+			},
+		}
+		if err := eml.Prepare(); err != nil {
+			t.Fatal(err)
+		}
+		first, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// boundaries are random so compare the attachment parts only
+		for _, content := range []string{"buffer content", "reader content"} {
+			want := base64.StdEncoding.EncodeToString([]byte(content))
+			if !strings.Contains(string(first), want) || !strings.Contains(string(second), want) {
+				t.Errorf("Attachment content is missing!\nwant:%s\nfirst:%s\nsecond:%s", want, first, second)
+			}
+		}
+		if len(first) != len(second) {
+			t.Errorf("Invalid email size!\nfirst:%v\nsecond:%v", len(first), len(second))
+		}
+	})
+	t.Run("Test file attachment", func(t *testing.T) {
+		fileName := filepath.Join(t.TempDir(), "report.txt")
+		if err := os.WriteFile(fileName, []byte("temp file content"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "File attachment",
+			TextBody:    "Hello",
+			Attachments: []Attachment{{Name: "report.txt", FileName: fileName}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("temp file content")); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment content is missing!\nwant:%s\ngot:%s", want, data)
+		}
+
+		// missing file is reported before composing the email
+		eml.Attachments[0].FileName = filepath.Join(t.TempDir(), "missing.txt")
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected error for missing attachment file!")
+		}
+	})
+	t.Run("Test cloning email", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "Original",
+			TextBody:    "Hello",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png"}},
+		}
+		eml.SetHeader("X-Campaign", "original")
+
+		clone := eml.Clone()
+		clone.Subject = "Clone"
+		clone.SetHeader("X-Campaign", "clone")
+		*clone.Recipients.ToAddresses[0] = "other@example.com"
+		clone.Attachments[0].Name = "Venus.png"
+
+		if got := eml.Headers.Get("X-Campaign"); got != "original" {
+			t.Errorf("Original header was modified!\nwant:%s\ngot:%s", "original", got)
+		}
+		if got := eml.Recipients.To(); got != "customer@example.com" {
+			t.Errorf("Original recipients were modified!\nwant:%s\ngot:%s", "customer@example.com", got)
+		}
+		if got := eml.Attachments[0].Name; got != "Mars.png" {
+			t.Errorf("Original attachment was modified!\nwant:%s\ngot:%s", "Mars.png", got)
+		}
+		if eml.Subject != "Original" || clone.Headers.Get("X-Campaign") != "clone" {
+			t.Errorf("Invalid clone!\ngot:%+v", clone)
+		}
+	})
+	t.Run("Test ContentID with angle brackets", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "ContentID",
+			HTMLBody:    "<img src='cid:1001'/>",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png", ContentID: "<1001>"}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-ID: <1001>\r\n"; !strings.Contains(string(data), want) || strings.Contains(string(data), "<<1001>>") {
+			t.Errorf("Invalid Content-ID!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.Attachments[0].ContentID = "10 01"
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected error for ContentID with whitespace!")
+		}
+	})
+	t.Run("Test envelope sender", func(t *testing.T) {
+		eml := Email{
+			From:       "Support <support@example.com>",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Envelope sender",
+			TextBody:   "Hello",
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input.Source != nil {
+			t.Errorf("Source should not be set by default!\ngot:%s", *input.Source)
+		}
+
+		eml.UseEnvelopeSender = true
+		if input, err = eml.GetSendRawEmailInput(); err != nil {
+			t.Fatal(err)
+		}
+		if input.Source == nil || *input.Source != eml.From {
+			t.Errorf("Invalid Source!\nwant:%s\ngot:%v", eml.From, input.Source)
+		}
+	})
+	t.Run("Test envelope sender different from From", func(t *testing.T) {
+		eml := Email{
+			From:              "Support <support@example.com>",
+			EnvelopeFrom:      "bounce+123@example.com",
+			UseEnvelopeSender: true,
+			Recipients:        NewRecipients("customer@example.com", "", ""),
+			Subject:           "Envelope sender",
+			TextBody:          "Hello",
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input.Source == nil || *input.Source != eml.EnvelopeFrom {
+			t.Errorf("Invalid Source!\nwant:%s\ngot:%v", eml.EnvelopeFrom, input.Source)
+		}
+		for _, want := range []string{"From: Support <support@example.com>\r\n", "Return-Path: bounce+123@example.com\r\n"} {
+			if !strings.Contains(string(input.RawMessage.Data), want) {
+				t.Errorf("Missing header!\nwant:%s\ngot:%s", want, input.RawMessage.Data)
+			}
+		}
+
+		eml.EnvelopeFrom = "not an address"
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected error for invalid EnvelopeFrom address!")
+		}
+	})
+	t.Run("Test Logger", func(t *testing.T) {
+		useMockSES(t)
+		var logs []string
+		Logger = func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) }
+		defer func() { Logger = nil }()
+
+		if err := SendTo("us-east-1", "no-reply@example.com", "customer@example.com", "Hello", "<h1>Hello</h1>"); err != nil {
+			t.Fatal(err)
+		}
+		if want := "raweml: sending email to 1 destination(s)"; !strings.Contains(strings.Join(logs, "\n"), want) {
+			t.Errorf("Send attempt was not logged!\nwant:%s\ngot:%v", want, logs)
+		}
+	})
+	t.Run("Test pixel tracking", func(t *testing.T) {
+		const pixel = `<img width="1" height="1" alt="" src="https://example.com/open?id=1&amp;c=2" />`
+		eml := Email{HTMLBody: "<html><BODY><p>Hello</p></BODY></html>"}
+		eml.EnablePixelTracking("https://example.com/open?id=1&c=2")
+		eml.EnablePixelTracking("https://example.com/open?id=1&c=2")
+		if want := "<html><BODY><p>Hello</p>" + pixel + "</BODY></html>"; eml.HTMLBody != want {
+			t.Errorf("Invalid tracking pixel!\nwant:%s\ngot:%s", want, eml.HTMLBody)
+		}
+
+		eml.HTMLBody = "<p>Hello</p>"
+		eml.EnablePixelTracking("https://example.com/open?id=1&c=2")
+		if want := "<p>Hello</p>" + pixel; eml.HTMLBody != want {
+			t.Errorf("Invalid tracking pixel!\nwant:%s\ngot:%s", want, eml.HTMLBody)
+		}
+	})
+	t.Run("Test text and HTML charset", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "Charset",
+			TextBody:    "Hello",
+			HTMLBody:    "<h1>Hello</h1>",
+			TextCharSet: "ISO-8859-1",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"Content-Type: text/plain; charset=ISO-8859-1\r\n", "Content-Type: text/html; charset=UTF-8\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Invalid charset!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test stable Message-ID", func(t *testing.T) {
+		eml := Email{
+			From:           "no-reply@example.com",
+			Recipients:     NewRecipients("customer@example.com", "", ""),
+			Subject:        "Message-ID",
+			TextBody:       "Hello",
+			IdempotencyKey: "order-1001",
+		}
+		getMessageID := func(e Email) string {
+			data, err := e.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(data), "\r\n") {
+				if strings.HasPrefix(line, "Message-Id: ") {
+					return strings.TrimPrefix(line, "Message-Id: ")
+				}
+			}
+			return ""
+		}
+		first, second := getMessageID(eml), getMessageID(eml)
+		if len(first) == 0 || first != second || !strings.HasSuffix(first, "@example.com>") {
+			t.Errorf("Invalid Message-ID!\nfirst:%s\nsecond:%s", first, second)
+		}
+
+		// generated Message-ID
+		eml.IdempotencyKey = ""
+		id := eml.GetMessageID()
+		if got := getMessageID(eml); got != id || id == first {
+			t.Errorf("Invalid generated Message-ID!\nwant:%s\ngot:%s", id, got)
+		}
+	})
+	t.Run("Test attachment name parameter", func(t *testing.T) {
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "Attachment name",
+			TextBody:    "Hello",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png", ContentType: "image/png"}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Type: image/png; name=\"Mars.png\"\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Missing name parameter!\nwant:%s\ngot:%s", want, data)
+		}
+
+		// non-ASCII name is RFC 2231 encoded
+		eml.Attachments[0].Name = "Марс.png"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Type: image/png; name*=utf-8''%D0%9C%D0%B0%D1%80%D1%81.png\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid name parameter!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test Recipients from slices", func(t *testing.T) {
+		r := RecipientsFromSlices([]string{" to_1@h.com", "", "to_2@h.com "}, nil, []string{"bcc_1@h.com"})
+		if want := "to_1@h.com,to_2@h.com"; r.To() != want {
+			t.Errorf("Invalid To recipients!\nwant:%s\ngot:%s", want, r.To())
+		}
+		if r.CcAddresses != nil || r.Cc() != "" {
+			t.Errorf("Cc recipients should be empty!\ngot:%s", r.Cc())
+		}
+		if want := "bcc_1@h.com"; r.Bcc() != want {
+			t.Errorf("Invalid Bcc recipients!\nwant:%s\ngot:%s", want, r.Bcc())
+		}
+	})
+	t.Run("Test Recipients Count and Contains", func(t *testing.T) {
+		r := NewRecipients("to_1@h.com,to_2@h.com", "cc_1@h.com", "Hidden <Bcc_1@h.com>")
+		if got := r.Count(); got != 4 {
+			t.Errorf("Invalid recipients count!\nwant:%v\ngot:%v", 4, got)
+		}
+		if !r.Contains("bcc_1@H.com") {
+			t.Errorf("Bcc recipient not found!")
+		}
+		if r.Contains("other@h.com") {
+			t.Errorf("Unexpected recipient found!")
+		}
+	})
+	t.Run("Test Precedence header", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Newsletter",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Precedence:") {
+			t.Errorf("Precedence header should be omitted!\ngot:%s", data)
+		}
+
+		eml.Precedence = "bulk"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "Precedence: bulk\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Missing Precedence header!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.Precedence = "urgent"
+		if err := eml.Validate(); err == nil {
+			t.Error("Expected error for invalid Precedence!")
+		}
+	})
+	t.Run("Test non-ASCII attachment filename", func(t *testing.T) {
+		const name = "🚀 launch.txt"
+		eml := Email{
+			From:        "no-reply@example.com",
+			Recipients:  NewRecipients("customer@example.com", "", ""),
+			Subject:     "Attachment filename",
+			TextBody:    "Hello",
+			Attachments: []Attachment{{Name: name, Data: strings.NewReader("launch")}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var disposition string
+		for _, line := range strings.Split(string(data), "\r\n") {
+			if strings.HasPrefix(line, "Content-Disposition: ") {
+				disposition = strings.TrimPrefix(line, "Content-Disposition: ")
+			}
+		}
+		if !strings.Contains(disposition, `filename="_ launch.txt"`) || !strings.Contains(disposition, "filename*=utf-8''") {
+			t.Errorf("Missing filename parameters!\ngot:%s", disposition)
+		}
+		if _, params, err := mime.ParseMediaType(disposition); err != nil || params["filename"] != name {
+			t.Errorf("Invalid decoded filename!\nwant:%s\ngot:%s (%v)", name, params["filename"], err)
+		}
+	})
+	t.Run("Test send timeout", func(t *testing.T) {
+		mock := useMockSES(t)
+		mock.block = true
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Timeout",
+			TextBody:   "Hello",
+			Timeout:    10 * time.Millisecond,
+		}
+		if _, err := eml.Send(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded error!\ngot:%v", err)
+		}
+	})
+	t.Run("Test Organization and X-Mailer headers", func(t *testing.T) {
+		eml := Email{
+			From:         "no-reply@example.com",
+			Recipients:   NewRecipients("customer@example.com", "", ""),
+			Subject:      "Branding",
+			TextBody:     "Hello",
+			Organization: "Example Inc.",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"Organization: Example Inc.\r\n", "X-Mailer: raweml/" + Version + "\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+
+		eml.SetHeader("X-Mailer", "MyApp/2.0")
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "X-Mailer: MyApp/2.0\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("X-Mailer was not overridden!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test minimal headers", func(t *testing.T) {
+		eml := Email{
+			From:       "no-reply@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "Minimal",
+			TextBody:   "Hello",
+			Minimal:    true,
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "Content-Transfer-Encoding: 7bit\r\n" +
+			"Content-Type: text/plain; charset=us-ascii\r\n" +
+			"From: no-reply@example.com\r\n" +
+			"Mime-Version: 1.0\r\n" +
+			"Subject: Minimal\r\n" +
+			"To: customer@example.com\r\n\r\n"
+		if !strings.HasPrefix(string(data), want) {
+			t.Errorf("Invalid minimal headers!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test Reply", func(t *testing.T) {
+		original := Email{
+			From:       "Customer <customer@example.com>",
+			Recipients: NewRecipients("support@example.com", "", ""),
+			Subject:    "FW: Broken widget",
+			TextBody:   "My widget is broken",
+			MessageID:  "<orig@example.com>",
+		}
+		reply := Reply(original, "<p>We are on it</p>", "We are on it")
+		if reply.Subject != "RE: FW: Broken widget" || reply.Topic != "Broken widget" {
+			t.Errorf("Invalid subject or topic!\ngot:%s / %s", reply.Subject, reply.Topic)
+		}
+		if reply.From != "support@example.com" || reply.Recipients.To() != original.From {
+			t.Errorf("Invalid sender or recipients!\ngot:%s / %s", reply.From, reply.Recipients.To())
+		}
+		if NewThread(reply.Topic).GetGUID() != NewThread(NormalizeSubject(original.Subject)).GetGUID() {
+			t.Errorf("Reply is not in the original thread!")
+		}
+		data, err := reply.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"In-Reply-To: <orig@example.com>\r\n", "References: <orig@example.com>\r\n", "Thread-Topic: Broken widget\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+		if again := Reply(reply, "", "Thanks"); again.Subject != reply.Subject {
+			t.Errorf("Subject prefix should not be repeated!\ngot:%s", again.Subject)
+		}
+	})
+	t.Run("Test Forward", func(t *testing.T) {
+		original := Email{
+			From:        "Customer <customer@example.com>",
+			Recipients:  NewRecipients("support@example.com", "", ""),
+			Subject:     "Broken widget",
+			TextBody:    "My widget is broken",
+			HTMLBody:    "<p>My widget is broken</p>",
+			Attachments: []Attachment{{Name: "widget.txt", Data: bytes.NewBufferString("widget log"), ContentType: "text/plain"}},
+		}
+		forward := Forward(original, NewRecipients("engineering@example.com", "", ""))
+		if forward.Subject != "FW: Broken widget" {
+			t.Errorf("Invalid subject!\nwant:%s\ngot:%s", "FW: Broken widget", forward.Subject)
+		}
+		if !strings.Contains(forward.TextBody, "> My widget is broken") || !strings.Contains(forward.HTMLBody, "<blockquote><p>My widget is broken</p></blockquote>") {
+			t.Errorf("Original bodies are not quoted!\ngot:%s\n%s", forward.TextBody, forward.HTMLBody)
+		}
+		want := base64.StdEncoding.EncodeToString([]byte("widget log"))
+		for i := 0; i < 2; i++ {
+			data, err := forward.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), `filename="widget.txt"`) || !strings.Contains(string(data), want) {
+				t.Errorf("Forwarded attachment is missing!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test envelope recipients", func(t *testing.T) {
+		eml := Email{
+			From:               "sender@example.com",
+			Recipients:         NewRecipients("list@example.com", "", ""),
+			EnvelopeRecipients: NewRecipients("", "", "alice@example.com,bob@example.com"),
+			Subject:            "Fan-out",
+			TextBody:           "Hello",
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"alice@example.com", "bob@example.com"}
+		if got := aws.StringValueSlice(input.Destinations); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Invalid destinations!\nwant:%s\ngot:%s", want, got)
+		}
+		raw := string(input.RawMessage.Data)
+		if !strings.Contains(raw, "To: list@example.com\r\n") || strings.Contains(raw, "alice@example.com") {
+			t.Errorf("Header To should show only the display recipients!\ngot:%s", raw)
+		}
+	})
+	t.Run("Test undisclosed recipients", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("alice@example.com", "bob@example.com", "carol@example.com"),
+			Subject:    "Newsletter",
+			TextBody:   "Hello",
+		}
+		eml.UndisclosedRecipients()
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := string(input.RawMessage.Data)
+		if !strings.Contains(raw, "To: undisclosed-recipients:;\r\n") {
+			t.Errorf("Missing undisclosed recipients group!\ngot:%s", raw)
+		}
+		want := []string{"alice@example.com", "bob@example.com", "carol@example.com"}
+		for _, addr := range want {
+			if strings.Contains(raw, addr) {
+				t.Errorf("Recipient %s is visible in the headers!\ngot:%s", addr, raw)
+			}
+		}
+		if got := aws.StringValueSlice(input.Destinations); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("Invalid destinations!\nwant:%s\ngot:%s", want, got)
+		}
+	})
+	t.Run("Test HTML body template", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Template",
+		}
+		eml.SetHTMLBodyTemplate(template.Must(template.New("body").Parse("<p>Hello {{.Name}}</p>")), struct{ Name string }{"Alice"})
+		buf := new(bytes.Buffer)
+		if _, err := eml.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		if want := "<p>Hello Alice</p>"; !strings.Contains(buf.String(), want) {
+			t.Errorf("Template is not rendered!\nwant:%s\ngot:%s", want, buf.String())
+		}
+
+		eml.SetHTMLBodyReader(strings.NewReader("<p>From reader</p>"))
+		for i := 0; i < 2; i++ {
+			data, err := eml.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "<p>From reader</p>"; !strings.Contains(string(data), want) {
+				t.Errorf("Reader body is missing!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test compose sentinel errors", func(t *testing.T) {
+		multiple := textproto.MIMEHeader{}
+		multiple.Add("X-Test", "a")
+		multiple.Add("X-Test", "b")
+		for name, tc := range map[string]struct {
+			email Email
+			want  error
+		}{
+			"empty email":     {Email{From: "sender@example.com", Recipients: NewRecipients("receiver@example.com", "", "")}, ErrEmptyEmail},
+			"no recipients":   {Email{From: "sender@example.com", TextBody: "Hello"}, ErrNoRecipients},
+			"multiple values": {Email{From: "sender@example.com", Recipients: NewRecipients("receiver@example.com", "", ""), TextBody: "Hello", Headers: multiple}, ErrMultipleHeaderValues},
+			"missing data":    {Email{From: "sender@example.com", Recipients: NewRecipients("receiver@example.com", "", ""), TextBody: "Hello", Attachments: []Attachment{{Name: "empty.txt"}}}, ErrAttachmentMissingData},
+		} {
+			if _, err := tc.email.Bytes(); !errors.Is(err, tc.want) {
+				t.Errorf("Invalid error for %s!\nwant:%v\ngot:%v", name, tc.want, err)
+			}
+		}
+	})
+	t.Run("Test References", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "RE: Hello",
+			TextBody:   "Hello",
+			Topic:      "Hello",
+			InReplyTo:  "<id2@example.com>",
+			References: []string{"<id1@example.com>", "id2@example.com"},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "References: <id1@example.com> <id2@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid References header!\nwant:%s\ngot:%s", want, data)
+		}
+		if !strings.Contains(string(data), "Thread-Index: ") {
+			t.Errorf("Missing Thread-Index header!\ngot:%s", data)
+		}
+
+		eml.References = nil
+		data, err = eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "References: <id2@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("References should default to InReplyTo!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test long non-ASCII subject", func(t *testing.T) {
+		subject := "これは非常に長い日本語の件名です。エンコードされた単語に分割する必要があります。"
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    subject,
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := msg.Header.Get("Subject")
+		words := strings.Fields(value)
+		if len(words) < 2 {
+			t.Errorf("Subject should be split into multiple encoded-words!\ngot:%s", value)
+		}
+		for _, word := range words {
+			if len(word) > 75 {
+				t.Errorf("Encoded-word is longer than 75 characters!\ngot:%s", word)
+			}
+		}
+		decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != subject {
+			t.Errorf("Invalid decoded subject!\nwant:%s\ngot:%s", subject, decoded)
+		}
+	})
+	t.Run("Test Size", func(t *testing.T) {
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Size",
+			TextBody:    "Hello",
+			HTMLBody:    "<p>Hello</p>",
+			Attachments: []Attachment{{Name: "Mars.png", FileName: "example/Mars.png"}},
+		}
+		size, err := eml.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != len(data) {
+			t.Errorf("Invalid size!\nwant:%d\ngot:%d", len(data), size)
+		}
+	})
+	t.Run("Test non-ASCII Thread-Topic", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Überraschung",
+			Topic:      "Überraschung",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Thread-Topic: =?utf-8?q?=C3=9Cberraschung?=\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Thread-Topic header!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test attachment from fs.FS", func(t *testing.T) {
+		fsys := fstest.MapFS{"assets/notes.txt": {Data: []byte("embedded notes")}}
+		if _, err := NewAttachmentFromFS(fsys, "assets/missing.txt"); err == nil {
+			t.Errorf("Missing file should return an error!")
+		}
+		item, err := NewAttachmentFromFS(fsys, "assets/notes.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Embedded",
+			TextBody:    "Hello",
+			Attachments: []Attachment{item},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := base64.StdEncoding.EncodeToString([]byte("embedded notes"))
+		if !strings.Contains(string(data), want) || !strings.Contains(string(data), `filename="notes.txt"`) {
+			t.Errorf("Embedded attachment is missing!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test WithSES", func(t *testing.T) {
+		eml := &Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Campaign",
+			TextBody:   "Hello",
+		}
+		data, err := eml.WithSES("marketing", map[string]string{"team": "sales", "campaign": "spring-2024"}).Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"X-Ses-Configuration-Set: marketing\r\n", "X-Ses-Message-Tags: campaign=spring-2024, team=sales\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing SES header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+		if _, err := eml.WithSES("market ing", nil).Bytes(); err == nil {
+			t.Errorf("Invalid configuration set should return an error!")
+		}
+		if _, err := eml.WithSES("marketing", map[string]string{"team": "sales, inc"}).Bytes(); err == nil {
+			t.Errorf("Invalid message tag should return an error!")
+		}
+	})
+	t.Run("Test Feedback validation", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Feedback",
+			TextBody:   "Hello",
+			Feedback:   "bounces@@example.com",
+		}
+		if err := eml.Validate(); err == nil || !strings.Contains(err.Error(), `Invalid Feedback address "bounces@@example.com"`) {
+			t.Errorf("Expected descriptive Feedback error!\ngot:%v", err)
+		}
+
+		eml.Feedback = "Bounces <bounces@example.com>"
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Return-Path: Bounces <bounces@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Return-Path!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test sending authorization ARNs", func(t *testing.T) {
+		eml := Email{
+			From:          "sender@example.com",
+			Recipients:    NewRecipients("receiver@example.com", "", ""),
+			Subject:       "Cross-account",
+			TextBody:      "Hello",
+			SourceArn:     "arn:aws:ses:us-east-1:123456789012:identity/example.com",
+			FromArn:       "arn:aws:ses:us-east-1:123456789012:identity/sender@example.com",
+			ReturnPathArn: "arn:aws:ses:us-east-1:123456789012:identity/bounces.example.com",
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for want, got := range map[string]*string{eml.SourceArn: input.SourceArn, eml.FromArn: input.FromArn, eml.ReturnPathArn: input.ReturnPathArn} {
+			if aws.StringValue(got) != want {
+				t.Errorf("Invalid ARN!\nwant:%s\ngot:%s", want, aws.StringValue(got))
+			}
+		}
+
+		eml.SourceArn, eml.FromArn, eml.ReturnPathArn = "", "", ""
+		if input, err = eml.GetSendRawEmailInput(); err != nil {
+			t.Fatal(err)
+		}
+		if input.SourceArn != nil || input.FromArn != nil || input.ReturnPathArn != nil {
+			t.Errorf("ARNs should not be set!")
+		}
+	})
+	t.Run("Test header audit", func(t *testing.T) {
+		// the multipart Content-Type replaces the user header with non-canonical key, so it is written once
+		multi := Email{
+			From:         "sender@example.com",
+			Recipients:   NewRecipients("receiver@example.com", "", ""),
+			Subject:      "Audit",
+			TextBody:     "Hello",
+			HTMLBody:     "<p>Hello</p>",
+			Headers:      textproto.MIMEHeader{"content-type": {"text/calendar"}},
+			AuditHeaders: true,
+		}
+		data, err := multi.Bytes()
+		if err != nil {
+			t.Fatalf("Unexpected audit error!\ngot:%v", err)
+		}
+		if n := strings.Count(strings.ToLower(string(data)), "\ncontent-type: multipart/"); n != 1 || strings.Contains(string(data), "text/calendar") {
+			t.Errorf("Expected exactly one Content-Type header!\ngot:%s", data)
+		}
+
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Audit",
+			TextBody:   "Hello",
+			Headers:    textproto.MIMEHeader{"content-type": {"text/calendar"}, "Content-Type": {"text/plain"}},
+		}
+		if _, err := eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		eml.AuditHeaders = true
+		if _, err := eml.Bytes(); err == nil || !strings.Contains(err.Error(), "Content-Type") {
+			t.Errorf("Expected duplicate Content-Type error!\ngot:%v", err)
+		}
+		eml.Headers = nil
+		if _, err := eml.Bytes(); err != nil {
+			t.Errorf("Unexpected audit error!\ngot:%v", err)
+		}
+	})
+	t.Run("Test user Content-Type for single-part body", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Markdown",
+			TextBody:   "# Hello",
+		}
+		eml.SetHeader("Content-Type", "text/markdown; charset=UTF-8")
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Type: text/markdown; charset=UTF-8\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("User Content-Type should be kept!\nwant:%s\ngot:%s", want, data)
+		}
+		if strings.Contains(string(data), "text/plain") {
+			t.Errorf("Default Content-Type should not be set!\ngot:%s", data)
+		}
+	})
+	t.Run("Test multipart closing boundaries", func(t *testing.T) {
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Multipart",
+			TextBody:    "Hello",
+			HTMLBody:    "<p>Hello</p>",
+			Attachments: []Attachment{{Name: "notes.txt", Data: strings.NewReader("notes"), ContentType: "text/plain"}},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var readParts func(contentType string, body io.Reader) int
+		readParts = func(contentType string, body io.Reader) int {
+			mediaType, params, err := mime.ParseMediaType(contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(mediaType, "multipart/") {
+				if _, err := io.ReadAll(body); err != nil {
+					t.Errorf("Part is not readable: %v", err)
+				}
+				return 1
+			}
+			if n := strings.Count(string(data), "--"+params["boundary"]+"--"); n != 1 {
+				t.Errorf("Closing boundary of %s should be written once, got %d", mediaType, n)
+			}
+			count := 0
+			reader := multipart.NewReader(body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Failed to read %s part: %v", mediaType, err)
+				}
+				count += readParts(part.Header.Get("Content-Type"), part)
+			}
+			return count
+		}
+		if n := readParts(msg.Header.Get("Content-Type"), msg.Body); n != 3 {
+			t.Errorf("Invalid number of parts!\nwant:%d\ngot:%d", 3, n)
+		}
+	})
+	t.Run("Test BytesWithBoundaries", func(t *testing.T) {
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Boundaries",
+			TextBody:    "Hello",
+			HTMLBody:    "<p>Hello</p>",
+			Attachments: []Attachment{{Name: "notes.txt", Data: strings.NewReader("notes")}},
+		}
+		data, boundaries, err := eml.BytesWithBoundaries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(boundaries) != 2 {
+			t.Fatalf("Expected outer and inner boundaries!\ngot:%v", boundaries)
+		}
+		for _, boundary := range boundaries {
+			if !strings.Contains(string(data), "--"+boundary+"\r\n") || !strings.Contains(string(data), "--"+boundary+"--") {
+				t.Errorf("Boundary %s is not in the raw data!\ngot:%s", boundary, data)
+			}
+		}
+		if want := `multipart/mixed; boundary="` + boundaries[0] + `"`; !strings.Contains(string(data), want) {
+			t.Errorf("First boundary should be the outer one!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.HTMLBody, eml.Attachments = "", nil
+		if _, boundaries, err = eml.BytesWithBoundaries(); err != nil || len(boundaries) != 0 {
+			t.Errorf("Single-part email should have no boundaries!\ngot:%v %v", boundaries, err)
+		}
+	})
+	t.Run("Test read receipt", func(t *testing.T) {
+		eml := Email{
+			From:          "sender@example.com",
+			Recipients:    NewRecipients("receiver@example.com", "", ""),
+			Subject:       "Receipt",
+			TextBody:      "Hello",
+			ReadReceiptTo: "Receipts <receipts@example.com>",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"Disposition-Notification-To: Receipts <receipts@example.com>\r\n", "Return-Receipt-To: Receipts <receipts@example.com>\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing read receipt header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+
+		eml.ReadReceiptTo = "not an address"
+		if _, err := eml.Bytes(); err == nil {
+			t.Error("Expected error for invalid ReadReceiptTo address!")
+		}
+	})
+	t.Run("Test us-ascii text charset", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Charset",
+			TextBody:   "Hello",
+		}
+		for body, want := range map[string]string{"Hello": "charset=us-ascii", "Здраво": "charset=UTF-8"} {
+			eml.TextBody = body
+			data, err := eml.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "Content-Type: text/plain; "+want+"\r\n") {
+				t.Errorf("Invalid charset for %q!\nwant:%s\ngot:%s", body, want, data)
+			}
+		}
+
+		eml.TextBody, eml.CharSet = "Hello", "UTF-8"
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "charset=UTF-8\r\n") {
+			t.Errorf("Explicit CharSet should be kept!\ngot:%s", data)
+		}
+	})
+	t.Run("Test AttachReader and AttachBytes", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Attachments",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("report.csv", strings.NewReader("a,b"), "text/csv")
+		eml.AttachBytes("data.json", []byte(`{"a":1}`), "")
+		tree, err := eml.MIMETree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"text/plain", "text/csv", "application/json"}
+		if len(tree.Parts) != len(want) {
+			t.Fatalf("Invalid number of parts!\nwant:%d\ngot:%d", len(want), len(tree.Parts))
+		}
+		for i, part := range tree.Parts {
+			if !strings.HasPrefix(part.ContentType, want[i]) {
+				t.Errorf("Invalid part %d content type!\nwant:%s\ngot:%s", i, want[i], part.ContentType)
+			}
+		}
+
+		var nilBuffer *bytes.Buffer
+		for _, r := range []io.Reader{nil, nilBuffer} {
+			eml.Attachments = eml.Attachments[:2]
+			eml.AttachReader("empty.bin", r, "")
+			if err := eml.Validate(); !errors.Is(err, ErrAttachmentMissingData) {
+				t.Errorf("Expected the missing data error for nil reader!\nwant:%s\ngot:%v", ErrAttachmentMissingData, err)
+			}
+			if _, err := eml.Bytes(); !errors.Is(err, ErrAttachmentMissingData) {
+				t.Errorf("Nil reader should not be sent as empty file!\nwant:%s\ngot:%v", ErrAttachmentMissingData, err)
+			}
+		}
+	})
+	t.Run("Test feedback as source", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Feedback:   "bounces@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Feedback as source",
+			TextBody:   "Hello",
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input.Source != nil {
+			t.Errorf("Source should not be set by default!\ngot:%s", *input.Source)
+		}
+
+		eml.FeedbackAsSource = true
+		if input, err = eml.GetSendRawEmailInput(); err != nil {
+			t.Fatal(err)
+		}
+		if aws.StringValue(input.Source) != eml.Feedback {
+			t.Errorf("Source should be set to Feedback!\nwant:%s\ngot:%v", eml.Feedback, aws.StringValue(input.Source))
+		}
+	})
+	t.Run("Test email thread namespace", func(t *testing.T) {
+		eml := Email{
+			From:            "sender@example.com",
+			Recipients:      NewRecipients("receiver@example.com", "", ""),
+			Subject:         "Hello world",
+			Topic:           "Hello world",
+			TextBody:        "Hello",
+			ThreadNamespace: uuid.NewSHA1(uuid.Nil, []byte("tenant-a")),
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread, err := ParseEmailThread(msg.Header.Get("Thread-Index"), eml.Topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := NewThreadInNamespace(eml.ThreadNamespace, eml.Topic).GetGUID(); thread.GetGUID() != want {
+			t.Errorf("Invalid thread GUID!\nwant:%s\ngot:%s", want, thread.GetGUID())
+		}
+	})
+	t.Run("Test SendMerge", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("ignored@example.com", "", ""),
+			Subject:    "Hello {{name}}",
+			TextBody:   "Dear {{name}}, unsubscribe with {{token}}",
+			HTMLBody:   "<p>Dear {{name}}</p>",
+		}
+		mock := &mockSES{}
+		err := eml.SendMerge(context.Background(), mock, map[string]map[string]string{
+			"bob@example.com":   {"name": "Bob & Co", "token": "b2"},
+			"alice@example.com": {"name": "Alice", "token": "a1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mock.inputs) != 2 {
+			t.Fatalf("Invalid number of sent emails!\nwant:%d\ngot:%d", 2, len(mock.inputs))
+		}
+		for i, want := range []struct{ to, subject, text, html string }{
+			{"alice@example.com", "Subject: Hello Alice\r\n", "Dear Alice, unsubscribe with a1", "<p>Dear Alice</p>"},
+			{"bob@example.com", "Subject: Hello Bob & Co\r\n", "Dear Bob & Co, unsubscribe with b2", "<p>Dear Bob &amp; Co</p>"},
+		} {
+			input := mock.inputs[i]
+			if got := aws.StringValueSlice(input.Destinations); len(got) != 1 || got[0] != want.to {
+				t.Errorf("Invalid destinations!\nwant:%s\ngot:%v", want.to, got)
+			}
+			raw := string(input.RawMessage.Data)
+			for _, s := range []string{"To: " + want.to + "\r\n", want.subject, want.text, want.html} {
+				if !strings.Contains(raw, s) {
+					t.Errorf("Missing merged value!\nwant:%s\ngot:%s", s, raw)
+				}
+			}
+		}
+	})
+	t.Run("Test blank topic", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Blank topic",
+			TextBody:   "Hello",
+		}
+		for _, topic := range []string{"   ", "RE:", "Re: Fwd: "} {
+			eml.Topic = topic
+			data, err := eml.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, header := range []string{"Thread-Index:", "Thread-Topic:", "References:"} {
+				if strings.Contains(string(data), header) {
+					t.Errorf("Unexpected %s header for topic %q!\ngot:%s", header, topic, data)
+				}
+			}
+		}
+	})
+	t.Run("Test InlineDataURIs", func(t *testing.T) {
+		png := base64.StdEncoding.EncodeToString([]byte("fake png data"))
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Data URI",
+			HTMLBody:   `<p>Logo</p><img alt="logo" src="data:image/png;base64,` + png + `">`,
+		}
+		if err := eml.InlineDataURIs(); err != nil {
+			t.Fatal(err)
+		}
+		if len(eml.Attachments) != 1 {
+			t.Fatalf("Expected one inline attachment!\ngot:%d", len(eml.Attachments))
+		}
+		item := eml.Attachments[0]
+		if !item.Inline || item.ContentType != "image/png" {
+			t.Errorf("Invalid inline attachment!\ngot:%+v", item)
+		}
+		if want := `<img alt="logo" src="cid:` + item.ContentID + `">`; !strings.Contains(eml.HTMLBody, want) {
+			t.Errorf("HTML should reference the attachment!\nwant:%s\ngot:%s", want, eml.HTMLBody)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), png) || strings.Contains(string(data), "data:image/png") {
+			t.Errorf("Data URI should be sent as an attachment!\ngot:%s", data)
+		}
+
+		eml.HTMLBody = `<img src="data:image/png;base64,%%%">`
+		if err := eml.InlineDataURIs(); err == nil {
+			t.Error("Expected error for invalid base64 data!")
+		}
+	})
+	t.Run("Test attachment name fallback", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Names",
+			TextBody:   "Hello",
+			Attachments: []Attachment{
+				{Data: strings.NewReader("first")},
+				{FileName: "example/Mars.png"},
+				{Name: "report.txt", Data: strings.NewReader("third")},
+			},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`filename="attachment-1"`, `filename="Mars.png"`, `filename="report.txt"`} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Missing attachment name!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test SendAll", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("alice@example.com,bob@example.com,carol@example.com", "", ""),
+			Subject:    "Individual",
+			TextBody:   "Hello",
+		}
+		mock := &mockSES{fail: "bob@example.com"}
+		failed := eml.SendAll(context.Background(), mock)
+		if len(failed) != 1 || failed["bob@example.com"] == nil {
+			t.Errorf("Only bob@example.com should fail!\ngot:%v", failed)
+		}
+		if len(mock.inputs) != 2 {
+			t.Fatalf("Invalid number of sent emails!\nwant:%d\ngot:%d", 2, len(mock.inputs))
+		}
+		for i, want := range []string{"alice@example.com", "carol@example.com"} {
+			if got := aws.StringValueSlice(mock.inputs[i].Destinations); len(got) != 1 || got[0] != want {
+				t.Errorf("Invalid destinations!\nwant:%s\ngot:%v", want, got)
+			}
+		}
+
+		if failed := eml.SendAll(context.Background(), nil); len(failed) != 3 || failed["alice@example.com"] == nil {
+			t.Errorf("All recipients should fail without the session!\ngot:%v", failed)
+		}
+	})
+	t.Run("Test header injection", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Hello\r\nBcc: evil@example.com",
+			TextBody:   "Hello",
+		}
+		data, err := eml.Bytes()
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected header injection error!\ngot:%v", err)
+		}
+		if strings.Contains(string(data), "evil@example.com") {
+			t.Errorf("Header was injected!\ngot:%s", data)
+		}
+
+		for _, value := range []string{"Hello\nBcc: evil@example.com", "Hello\x00"} {
+			eml.Subject = "Hello"
+			eml.SetHeader("X-Custom", value)
+			if _, err := eml.Bytes(); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("Expected header injection error for %q!\ngot:%v", value, err)
+			}
+		}
+	})
+	t.Run("Test recipient injection", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("alice@example.com\r\nBcc: evil@example.com", "", ""),
+			Subject:    "Recipients",
+			TextBody:   "Hello",
+		}
+		_, err := eml.Bytes()
+		if !errors.Is(err, ErrInvalidRecipient) || !strings.Contains(err.Error(), "control characters are not allowed") {
+			t.Errorf("Expected invalid recipient error!\ngot:%v", err)
+		}
+
+		eml.Recipients = NewRecipients("alice@example.com", "", "")
+		eml.EnvelopeRecipients = RecipientsFromSlices(nil, nil, []string{"bob@example.com\nBcc: evil@example.com"})
+		if err := eml.Validate(); !errors.Is(err, ErrInvalidRecipient) {
+			t.Errorf("Expected invalid envelope recipient error!\ngot:%v", err)
+		}
+	})
+	t.Run("Test raw body", func(t *testing.T) {
+		rawBody := "--b1\r\nContent-Type: text/plain\r\n\r\nSigned text\r\n--b1\r\nContent-Type: application/pkcs7-signature; name=smime.p7s\r\n\r\nMIIB\r\n--b1--\r\n"
+		eml := Email{
+			From:           "sender@example.com",
+			Recipients:     NewRecipients("receiver@example.com", "", ""),
+			Subject:        "Signed",
+			TextBody:       "ignored",
+			RawBody:        []byte(rawBody),
+			RawContentType: `multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256; boundary="b1"`,
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Content-Type: " + eml.RawContentType + "\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Content-Type!\nwant:%s\ngot:%s", want, data)
+		}
+		if !strings.HasSuffix(string(data), "\r\n\r\n"+rawBody) || strings.Contains(string(data), "ignored") {
+			t.Errorf("Raw body should be sent untouched!\nwant:%s\ngot:%s", rawBody, data)
+		}
+
+		eml.RawContentType = ""
+		if _, err := eml.Bytes(); err == nil {
+			t.Error("Expected error for missing RawContentType!")
+		}
+	})
+	t.Run("Test SendConcurrent", func(t *testing.T) {
+		emails := make([]Email, 100)
+		for i := range emails {
+			emails[i] = Email{
+				From:       "sender@example.com",
+				Recipients: NewRecipients(fmt.Sprintf("user%d@example.com", i), "", ""),
+				Subject:    "Concurrent",
+				TextBody:   "Hello",
+			}
+		}
+		emails[7].Recipients = NewRecipients("fail@example.com", "", "")
+		mock := &mockSES{fail: "fail@example.com", delay: time.Millisecond}
+		results := SendConcurrent(context.Background(), mock, emails, 5)
+		if len(results) != len(emails) {
+			t.Fatalf("Invalid number of results!\nwant:%d\ngot:%d", len(emails), len(results))
+		}
+		for i, res := range results {
+			if (res.Err != nil) != (i == 7) {
+				t.Errorf("Invalid result of email %d!\ngot:%v", i, res.Err)
+			}
+		}
+		if len(mock.inputs) != 99 {
+			t.Errorf("Invalid number of sent emails!\nwant:%d\ngot:%d", 99, len(mock.inputs))
+		}
+		if mock.maxActive > 5 || mock.maxActive < 1 {
+			t.Errorf("Concurrency limit exceeded!\nwant:<=5\ngot:%d", mock.maxActive)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for i, res := range SendConcurrent(ctx, &mockSES{}, emails, 5) {
+			if !errors.Is(res.Err, context.Canceled) {
+				t.Errorf("Email %d should not be sent after cancellation!\ngot:%v", i, res.Err)
+			}
+		}
+	})
+	t.Run("Test attachment order", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Attachments",
+			TextBody:   "Hello",
+		}
+		for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+			eml.AttachBytes(name, []byte(name), "text/plain")
+		}
+		positions := func(data []byte) []int {
+			var pos []int
+			for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+				pos = append(pos, bytes.Index(data, []byte(`filename="`+name+`"`)))
+			}
+			return pos
+		}
+
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pos := positions(data); !(pos[2] < pos[0] && pos[0] < pos[1]) {
+			t.Errorf("Attachments should keep the slice order!\nwant:c.txt,a.txt,b.txt\ngot:%s", data)
+		}
+
+		eml.SortAttachments = true
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if pos := positions(data); !(pos[0] < pos[1] && pos[1] < pos[2]) {
+			t.Errorf("Attachments should be sorted by name!\nwant:a.txt,b.txt,c.txt\ngot:%s", data)
+		}
+		if eml.Attachments[0].Name != "c.txt" {
+			t.Errorf("Sorting should not modify the Attachments!\nwant:c.txt\ngot:%s", eml.Attachments[0].Name)
+		}
+	})
+	t.Run("Test In-Reply-To list", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "RE: Hello",
+			TextBody:   "Hello",
+			InReplyTo:  " <id1@example.com>   <id2@example.com> ",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "In-Reply-To: <id1@example.com> <id2@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid In-Reply-To header!\nwant:%s\ngot:%s", want, data)
+		}
+		if want := "References: <id1@example.com> <id2@example.com>\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("References should default to InReplyTo!\nwant:%s\ngot:%s", want, data)
+		}
+
+		eml.InReplyTo = "<id1@example.com> id2@example.com"
+		if _, err := eml.Bytes(); !errors.Is(err, ErrInvalidMessageID) {
+			t.Errorf("Message-ID without angle brackets should fail!\nwant:%s\ngot:%v", ErrInvalidMessageID, err)
+		}
+	})
+	t.Run("Test FinalHeaders", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Snapshot",
+			TextBody:   "Hello",
+		}
+		eml.SetHeader("X-Custom", "custom value")
+		headers, err := eml.FinalHeaders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]string{
+			"X-Custom":     "custom value",
+			"From":         "sender@example.com",
+			"To":           "receiver@example.com",
+			"Subject":      "Snapshot",
+			"Mime-Version": "1.0",
+			"Content-Type": "text/plain; charset=us-ascii",
+		} {
+			if got := headers.Get(key); got != want {
+				t.Errorf("Invalid %s header!\nwant:%s\ngot:%s", key, want, got)
+			}
+		}
+		headers.Set("X-Custom", "changed")
+		if got := eml.Headers.Get("X-Custom"); got != "custom value" {
+			t.Errorf("Snapshot should not change the email!\nwant:%s\ngot:%s", "custom value", got)
+		}
+		if _, ok := eml.Headers["From"]; ok {
+			t.Errorf("Snapshot should not add headers to the email!\ngot:%v", eml.Headers)
+		}
+	})
+	t.Run("Test NewAttachmentFromURL", func(t *testing.T) {
+		content := []byte("%PDF-1.4 generated report")
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/reports/report.pdf" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(content)
+		}))
+		defer server.Close()
+
+		att, err := NewAttachmentFromURL(context.Background(), server.Client(), server.URL+"/reports/report.pdf?X-Amz-Signature=abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if att.Name != "report.pdf" || att.ContentType != "application/pdf" {
+			t.Errorf("Invalid attachment!\nwant:report.pdf application/pdf\ngot:%s %s", att.Name, att.ContentType)
+		}
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Report",
+			TextBody:    "See the attached report",
+			Attachments: []Attachment{att},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString(content); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment content is missing!\nwant:%s\ngot:%s", want, data)
+		}
+		if want := `Content-Type: application/pdf; name="report.pdf"`; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid attachment Content-Type!\nwant:%s\ngot:%s", want, data)
+		}
+
+		if _, err := NewAttachmentFromURL(context.Background(), server.Client(), server.URL+"/missing.pdf"); err == nil {
+			t.Errorf("Missing file should fail!")
+		}
+	})
+	t.Run("Test SES header conflicts", func(t *testing.T) {
+		eml := Email{
+			From:        "sender@example.com",
+			Recipients:  NewRecipients("receiver@example.com", "", ""),
+			Subject:     "Tags",
+			TextBody:    "Hello",
+			MessageTags: map[string]string{"campaign": "spring"},
+		}
+		eml.SetHeader("X-SES-MESSAGE-TAGS", "campaign=autumn")
+		_, err := eml.Bytes()
+		if !errors.Is(err, ErrSESHeaderConflict) || !strings.Contains(err.Error(), "MessageTags") {
+			t.Errorf("Expected the message tags conflict!\nwant:%s\ngot:%v", ErrSESHeaderConflict, err)
+		}
+
+		eml.MessageTags = nil
+		if _, err := eml.Bytes(); err != nil {
+			t.Errorf("Header without the field should not conflict!\ngot:%v", err)
+		}
+
+		eml.Feedback = "bounces@example.com"
+		eml.SetHeader("return-path", "other@example.com")
+		if _, err := eml.Bytes(); !errors.Is(err, ErrSESHeaderConflict) {
+			t.Errorf("Expected the Return-Path conflict!\nwant:%s\ngot:%v", ErrSESHeaderConflict, err)
+		}
+
+		eml.RemoveHeader("Return-Path")
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Errorf("Removed header should not conflict!\ngot:%v", err)
+		}
+		if strings.Contains(string(data), "Return-Path:") {
+			t.Errorf("Removed Return-Path header should be omitted!\ngot:%s", data)
+		}
+	})
+	t.Run("Test attachment parts", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Attachment",
+			TextBody:   "Hello",
+		}
+		eml.AttachBytes("notes.txt", []byte("first attachment"), "text/plain")
+		eml.AttachBytes("data.bin", []byte{0, 1, 2, 3}, "")
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		var names []string
+		var contents [][]byte
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read the part!\nerror:%s", err)
+			}
+			if part.FileName() == "" {
+				continue
+			}
+			encoded, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(string(encoded))
+			if err != nil {
+				t.Fatalf("Invalid base64 content of %s!\nerror:%s", part.FileName(), err)
+			}
+			names = append(names, part.FileName())
+			contents = append(contents, decoded)
+		}
+		if len(names) != 2 || names[0] != "notes.txt" || names[1] != "data.bin" {
+			t.Fatalf("Invalid attachments!\nwant:[notes.txt data.bin]\ngot:%v", names)
+		}
+		if string(contents[0]) != "first attachment" || !bytes.Equal(contents[1], []byte{0, 1, 2, 3}) {
+			t.Errorf("Invalid attachment content!\ngot:%q", contents)
+		}
+		if want := "--" + params["boundary"] + "--\r\n"; !strings.HasSuffix(string(data), want) {
+			t.Errorf("Email should end with the closing boundary!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test body part disposition", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Body",
+			TextBody:   "Hello",
+			HTMLBody:   "<p>Hello</p>",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Content-Disposition") {
+			t.Errorf("Body parts should not have Content-Disposition by default!\ngot:%s", data)
+		}
+
+		eml.HTMLBodyFileName = "body.html"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		want := "Content-Disposition: inline; filename=\"body.html\"\r\nContent-Transfer-Encoding: 7bit\r\nContent-Type: text/html; charset=UTF-8"
+		if !strings.Contains(string(data), want) {
+			t.Errorf("HTML part should have the Content-Disposition with the filename!\nwant:%s\ngot:%s", want, data)
+		}
+		if strings.Count(string(data), "Content-Disposition") != 1 {
+			t.Errorf("Only the HTML part should have the Content-Disposition!\ngot:%s", data)
+		}
+	})
+	t.Run("Test merging multipart Content-Type", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Related",
+			HTMLBody:   `<img src="cid:logo">`,
+		}
+		eml.AttachBytes("logo.png", []byte("png"), "image/png")
+		eml.SetHeader("Content-Type", `multipart/related; type="text/html"`)
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the media type matches the composed body, only the parameters are merged
+		if mediaType != "multipart/mixed" || params["type"] != "text/html" || len(params["boundary"]) == 0 {
+			t.Errorf("User parameters should be merged with the boundary!\nwant:multipart/mixed; boundary=*; type=\"text/html\"\ngot:%s", msg.Header.Get("Content-Type"))
+		}
+		if want := "--" + params["boundary"] + "--"; !strings.Contains(string(data), want) {
+			t.Errorf("Content-Type boundary should match the body!\nwant:%s\ngot:%s", want, data)
+		}
+
+		// the user header with non-canonical key is replaced as well
+		eml.Headers = textproto.MIMEHeader{"content-type": {`multipart/mixed; type="text/html"`}}
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		if msg, err = mail.ReadMessage(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Header["Content-Type"]; len(got) != 1 || !strings.Contains(got[0], `type="text/html"`) {
+			t.Errorf("Expected single merged Content-Type header!\ngot:%v", got)
+		}
+	})
+	t.Run("Test RenderAsRecipient", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("to@example.com", "cc@example.com", "hidden@example.com,secret@example.com"),
+			Subject:    "Preview",
+			TextBody:   "Hello",
+		}
+		eml.SetHeader("bcc", "manual@example.com")
+		data := eml.RenderAsRecipient()
+		if data == nil {
+			t.Fatal("Preview should be rendered!")
+		}
+		for _, bcc := range []string{"Bcc", "hidden@example.com", "secret@example.com", "manual@example.com"} {
+			if strings.Contains(string(data), bcc) {
+				t.Errorf("Preview should not contain %s!\ngot:%s", bcc, data)
+			}
+		}
+		for _, want := range []string{"To: to@example.com\r\n", "Cc: cc@example.com\r\n"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Preview should contain the visible recipients!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+		if eml.Recipients.Bcc() != "hidden@example.com,secret@example.com" {
+			t.Errorf("Preview should not change the email!\ngot:%s", eml.Recipients.Bcc())
+		}
+
+		eml.Recipients = NewRecipients("", "", "hidden@example.com")
+		data = eml.RenderAsRecipient()
+		if !strings.Contains(string(data), "To: undisclosed-recipients:;\r\n") || strings.Contains(string(data), "hidden@example.com") {
+			t.Errorf("Bcc only email should be previewed with undisclosed recipients!\ngot:%s", data)
+		}
+	})
+	t.Run("Test reusable attachment", func(t *testing.T) {
+		content := "shared report content"
+		shared, err := Attachment{Name: "report.txt", Data: bytes.NewBufferString(content), ContentType: "text/plain"}.Reusable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock := &mockSES{}
+		for _, addr := range []string{"alice@example.com", "bob@example.com"} {
+			eml := Email{
+				From:        "sender@example.com",
+				Recipients:  NewRecipients(addr, "", ""),
+				Subject:     "Report",
+				TextBody:    "See the attached report",
+				Attachments: []Attachment{shared},
+			}
+			if _, err := eml.SendWithSession(mock, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := base64.StdEncoding.EncodeToString([]byte(content))
+		for i, input := range mock.inputs {
+			if !strings.Contains(string(input.RawMessage.Data), want) {
+				t.Errorf("Email %d should contain the attachment content!\nwant:%s\ngot:%s", i+1, want, input.RawMessage.Data)
+			}
+		}
+		if len(mock.inputs) != 2 {
+			t.Errorf("Invalid number of sent emails!\nwant:%d\ngot:%d", 2, len(mock.inputs))
+		}
+		if _, err := (Attachment{Name: "empty.txt"}).Reusable(); !errors.Is(err, ErrAttachmentMissingData) {
+			t.Errorf("Attachment without data should fail!\nwant:%s\ngot:%v", ErrAttachmentMissingData, err)
+		}
+	})
+	t.Run("Test body line endings", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Lines",
+			TextBody:   "line 1\nline 2\r\nline 3\rline 4",
+		}
+		wantText := "line 1\r\nline 2\r\nline 3\r\nline 4\r\n"
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(data), "\r\n\r\n"+wantText) {
+			t.Errorf("Single part body should use CRLF line endings!\nwant:%q\ngot:%q", wantText, data)
+		}
+
+		eml.HTMLBody = "<p>\nHello\n</p>"
+		if data, err = eml.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"line 1\r\nline 2\r\nline 3\r\nline 4", "<p>\r\nHello\r\n</p>"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Body part should use CRLF line endings!\nwant:%q\ngot:%q", want, data)
+			}
+		}
+		if bare := regexp.MustCompile("[^\r]\n|\r[^\n]").FindString(string(data)); len(bare) > 0 {
+			t.Errorf("Email should not contain bare line endings!\ngot:%q", data)
+		}
+	})
+	t.Run("Test attachment name reconciliation", func(t *testing.T) {
+		var logs []string
+		Logger = func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) }
+		defer func() { Logger = nil }()
+
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Names",
+			TextBody:   "Hello",
+			Attachments: []Attachment{
+				{Name: "Mars.png", Data: bytes.NewReader([]byte("mars")), ContentType: `image/png; name="Earth.png"`},
+				{Data: bytes.NewReader([]byte("venus")), ContentType: `image/png; name="Venus.png"`},
+			},
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			`Content-Disposition: attachment; filename="Mars.png"`,
+			`Content-Type: image/png; name="Mars.png"`,
+			`Content-Disposition: attachment; filename="Venus.png"`,
+			`Content-Type: image/png; name="Venus.png"`,
+		} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Attachment names should agree!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+		if strings.Contains(string(data), "Earth.png") {
+			t.Errorf("Mismatched Content-Type name should be replaced!\ngot:%s", data)
+		}
+		warned := false
+		for _, line := range logs {
+			warned = warned || strings.Contains(line, `"Earth.png"`)
+		}
+		if !warned {
+			t.Errorf("Name mismatch should be logged!\ngot:%v", logs)
+		}
+	})
+	t.Run("Test base64 body encoding", func(t *testing.T) {
+		html := "<html><body>\n<p>" + strings.Repeat("Long line that gateways like to break ", 10) + "</p>\n<p>Ünïcode</p>\n</body></html>"
+		eml := Email{
+			From:         "sender@example.com",
+			Recipients:   NewRecipients("receiver@example.com", "", ""),
+			Subject:      "Base64",
+			TextBody:     "Hello",
+			HTMLBody:     html,
+			BodyEncoding: "base64",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		found := false
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+				continue
+			}
+			found = true
+			if cte := part.Header.Get("Content-Transfer-Encoding"); cte != "base64" {
+				t.Errorf("Invalid Content-Transfer-Encoding!\nwant:base64\ngot:%s", cte)
+			}
+			encoded, _ := io.ReadAll(part)
+			for _, line := range strings.Split(string(encoded), "\r\n") {
+				if len(line) > 76 {
+					t.Errorf("Base64 line is longer than 76 characters!\ngot:%s", line)
+				}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.ReplaceAll(html, "\n", "\r\n"); string(decoded) != want {
+				t.Errorf("Decoded HTML doesn't match!\nwant:%s\ngot:%s", want, decoded)
+			}
+		}
+		if !found {
+			t.Errorf("HTML part is missing!\ngot:%s", data)
+		}
+
+		eml.BodyEncoding = "uuencode"
+		if _, err := eml.Bytes(); err == nil {
+			t.Errorf("Unknown body encoding should fail!")
+		}
+	})
+	t.Run("Test SES size limit", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Large",
+			TextBody:   "Hello",
+		}
+		eml.AttachBytes("large.bin", make([]byte, MaxMessageSize), "")
+		if _, err := eml.Bytes(); err != nil {
+			t.Errorf("Size limit should not apply to composing the email (e.g. SMTP)!\ngot:%v", err)
+		}
+		if _, err := eml.GetSendRawEmailInput(); !errors.Is(err, ErrMessageTooLarge) {
+			t.Errorf("SES input should fail for the email larger than MaxMessageSize!\nwant:%s\ngot:%v", ErrMessageTooLarge, err)
+		}
+	})
+	t.Run("Test Size before SendAll", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("alice@example.com,bob@example.com", "", ""),
+			Subject:    "Individual",
+			TextBody:   "Hello",
+		}
+		if _, err := eml.Size(); err != nil {
+			t.Fatalf("Failed to get the email size!\ngot:%v", err)
+		}
+		if len(eml.Headers) != 0 {
+			t.Errorf("Size should not change the email headers!\ngot:%v", eml.Headers)
+		}
+		mock := &mockSES{}
+		if failed := eml.SendAll(context.Background(), mock); len(failed) != 0 {
+			t.Fatalf("Failed to send the emails!\ngot:%v", failed)
+		}
+		if len(mock.inputs) != 2 {
+			t.Fatalf("Invalid number of sent emails!\nwant:%d\ngot:%d", 2, len(mock.inputs))
+		}
+		for _, input := range mock.inputs {
+			dest := aws.StringValueSlice(input.Destinations)
+			want := "To: " + dest[0] + crlf
+			if data := string(input.RawMessage.Data); !strings.Contains(data, want) || strings.Count(data, "\nTo: ") != 1 {
+				t.Errorf("Invalid To header!\nwant:%s\ngot:%s", want, data)
+			}
+		}
+	})
+	t.Run("Test Size with non-seekable attachment", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Size",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("data.txt", io.MultiReader(strings.NewReader("data")), "text/plain")
+		if _, err := eml.Size(); err == nil {
+			t.Errorf("Expected error for the non-seekable attachment!")
+		}
+		if err := eml.Prepare(); err != nil {
+			t.Fatalf("Failed to prepare the email!\ngot:%v", err)
+		}
+		if _, err := eml.Size(); err != nil {
+			t.Errorf("Failed to get the size of the prepared email!\ngot:%v", err)
+		}
+	})
+	t.Run("Test part header injection", func(t *testing.T) {
+		tests := []func(eml *Email){
+			func(eml *Email) { eml.AttachBytes("a.txt\r\nX-Evil: 1", []byte("data"), "text/plain") },
+			func(eml *Email) { eml.AttachBytes("a.txt", []byte("data"), "text/plain\r\nX-Evil: 1") },
+			func(eml *Email) { eml.TextBodyFileName = "body.txt\nX-Evil: 1" },
+			func(eml *Email) { eml.HTMLBody, eml.HTMLBodyFileName = "<p>Hello</p>", "body.html\r\nX-Evil: 1" },
+		}
+		for i, set := range tests {
+			eml := Email{
+				From:       "sender@example.com",
+				Recipients: NewRecipients("receiver@example.com", "", ""),
+				Subject:    "Hello",
+				TextBody:   "Hello",
+			}
+			set(&eml)
+			data, err := eml.Bytes()
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("Expected part header injection error (%d)!\ngot:%v", i, err)
+			}
+			if strings.Contains(string(data), "X-Evil") {
+				t.Errorf("Part header was injected (%d)!\ngot:%s", i, data)
+			}
+		}
+	})
+	t.Run("Test FinalHeaders with non-seekable attachment", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Headers",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("data.txt", io.MultiReader(strings.NewReader("data")), "text/plain")
+		if _, err := eml.FinalHeaders(); !errors.Is(err, ErrNonSeekableReader) {
+			t.Errorf("Expected the non-seekable reader error!\nwant:%s\ngot:%v", ErrNonSeekableReader, err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("data")); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment data should not be read by FinalHeaders!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+	t.Run("Test RenderAsRecipient with non-seekable attachment", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Preview",
+			TextBody:   "Hello",
+		}
+		eml.AttachReader("data.txt", io.MultiReader(strings.NewReader("data")), "text/plain")
+		if data := eml.RenderAsRecipient(); data != nil {
+			t.Errorf("Email with non-seekable reader should not be rendered!\ngot:%s", data)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := base64.StdEncoding.EncodeToString([]byte("data")); !strings.Contains(string(data), want) {
+			t.Errorf("Attachment data should not be read by RenderAsRecipient!\nwant:%s\ngot:%s", want, data)
+		}
+	})
+}
+
+// helping functions -----------------------
+
+// mockSES records the sent emails instead of sending them
+type mockSES struct {
+	sesiface.SESAPI
+	inputs []*ses.SendRawEmailInput
+	block  bool          // block until the context is done
+	fail   string        // destination address that fails to send
+	delay  time.Duration // time to wait before sending
+
+	mu        sync.Mutex
+	active    int // number of the sends in progress
+	maxActive int // maximum number of the concurrent sends
+}
+
+func (m *mockSES) SendRawEmail(input *ses.SendRawEmailInput) (*ses.SendRawEmailOutput, error) {
+	for _, dest := range aws.StringValueSlice(input.Destinations) {
+		if dest == m.fail {
+			return nil, errors.New("mock failure for " + dest)
+		}
+	}
+	m.mu.Lock()
+	m.inputs = append(m.inputs, input)
+	m.mu.Unlock()
+	return &ses.SendRawEmailOutput{MessageId: aws.String("test-message-id")}, nil
+}
+
+func (m *mockSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	if m.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	m.mu.Lock()
+	m.active++
+	if m.active > m.maxActive {
+		m.maxActive = m.active
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.active--
+		m.mu.Unlock()
+	}()
+	time.Sleep(m.delay)
+	return m.SendRawEmail(input)
+}
+
+// useMockSES replaces the SES client used by Send with the returned mock until the test is done
+func useMockSES(t *testing.T) *mockSES {
+	mock := &mockSES{}
+	newClient := newSESClient
+	newSESClient = func(region string) sesiface.SESAPI { return mock }
+	t.Cleanup(func() { newSESClient = newClient })
+	return mock
+}
+
+// / helping functions -----------------------
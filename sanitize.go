@@ -0,0 +1,290 @@
+package raweml
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// removedElements are removed from the HTML body together with their content when Email.SanitizeHTML is enabled
+var removedElements = map[string]bool{
+	"script": true, "style": true, "svg": true, "math": true, "title": true, "template": true, "noscript": true,
+	"iframe": true, "frame": true, "frameset": true, "noframes": true, "noembed": true, "object": true, "embed": true,
+	"applet": true, "form": true, "textarea": true, "select": true, "xmp": true, "plaintext": true,
+}
+
+// allowedTags are the only tags kept in the HTML body. Any other tag is removed while its content is kept.
+var allowedTags = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "address": true, "area": true, "article": true, "aside": true, "b": true,
+	"bdi": true, "bdo": true, "big": true, "blockquote": true, "body": true, "br": true, "caption": true, "center": true,
+	"cite": true, "code": true, "col": true, "colgroup": true, "dd": true, "del": true, "details": true, "dfn": true,
+	"div": true, "dl": true, "dt": true, "em": true, "figcaption": true, "figure": true, "font": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "head": true, "header": true, "hr": true,
+	"html": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true, "map": true, "mark": true, "ol": true,
+	"p": true, "pre": true, "q": true, "rp": true, "rt": true, "ruby": true, "s": true, "samp": true, "section": true,
+	"small": true, "span": true, "strike": true, "strong": true, "sub": true, "summary": true, "sup": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "time": true, "tr": true, "tt": true, "u": true,
+	"ul": true, "var": true, "wbr": true,
+}
+
+// globalAttributes are the attributes allowed on all the allowed tags
+var globalAttributes = map[string]bool{
+	"align": true, "bgcolor": true, "border": true, "class": true, "color": true, "dir": true, "height": true,
+	"id": true, "lang": true, "style": true, "title": true, "valign": true, "width": true,
+}
+
+// tagAttributes are the attributes allowed only on the specific tags
+var tagAttributes = map[string]map[string]bool{
+	"a":          {"href": true, "name": true, "rel": true, "target": true},
+	"area":       {"alt": true, "coords": true, "href": true, "shape": true},
+	"blockquote": {"cite": true},
+	"body":       {"background": true},
+	"col":        {"span": true},
+	"colgroup":   {"span": true},
+	"del":        {"cite": true, "datetime": true},
+	"font":       {"face": true, "size": true},
+	"img":        {"alt": true, "hspace": true, "src": true, "vspace": true},
+	"ins":        {"cite": true, "datetime": true},
+	"li":         {"value": true},
+	"map":        {"name": true},
+	"ol":         {"start": true, "type": true},
+	"q":          {"cite": true},
+	"table":      {"background": true, "cellpadding": true, "cellspacing": true, "summary": true},
+	"td":         {"background": true, "colspan": true, "headers": true, "nowrap": true, "rowspan": true},
+	"th":         {"background": true, "colspan": true, "headers": true, "nowrap": true, "rowspan": true, "scope": true},
+	"time":       {"datetime": true},
+	"ul":         {"type": true},
+}
+
+// urlAttributes are the attributes with URL values that are checked against the allowedURLSchemes
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true, "background": true,
+}
+
+// allowedURLSchemes are the only URL schemes kept in the URL attributes (relative URLs are kept as well)
+var allowedURLSchemes = map[string]bool{
+	"http": true, "https": true, "mailto": true, "cid": true,
+}
+
+// htmlTag is a parsed HTML start or end tag
+type htmlTag struct {
+	name        string
+	closing     bool
+	selfClosing bool
+	attrs       [][2]string
+}
+
+// sanitizeHTML keeps only the allowedTags with their allowed attributes and the URLs with the allowedURLSchemes.
+// The removedElements (e.g. script, style, svg, math) are removed together with their content.
+// Inline styles and the "cid:" image references are kept.
+func sanitizeHTML(s string) string {
+	b := new(strings.Builder)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		// comments (including the conditional comments) are removed
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s[4:], "-->")
+			if end < 0 {
+				break
+			}
+			s = s[4+end+3:]
+			continue
+		}
+		// doctype is kept, other declarations (e.g. CDATA) are removed
+		if strings.HasPrefix(s, "<!") {
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				break
+			}
+			if strings.HasPrefix(strings.ToLower(s), "<!doctype") {
+				b.WriteString(s[:end+1])
+			}
+			s = s[end+1:]
+			continue
+		}
+
+		tag, rest, ok := parseHTMLTag(s)
+		if !ok {
+			b.WriteString("&lt;")
+			s = s[1:]
+			continue
+		}
+		s = rest
+		switch {
+		case removedElements[tag.name]:
+			if !tag.closing && !tag.selfClosing {
+				s = skipHTMLElement(s, tag.name)
+			}
+		case allowedTags[tag.name]:
+			tag.write(b)
+		}
+	}
+	return b.String()
+}
+
+// skipHTMLElement returns the HTML after the closing tag of the element
+func skipHTMLElement(s, name string) string {
+	end := strings.Index(strings.ToLower(s), "</"+name)
+	if end < 0 {
+		return ""
+	}
+	s = s[end:]
+	if gt := strings.IndexByte(s, '>'); gt >= 0 {
+		return s[gt+1:]
+	}
+	return ""
+}
+
+// parseHTMLTag parses the tag at the beginning of s and returns the HTML after the tag
+func parseHTMLTag(s string) (tag htmlTag, rest string, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		tag.closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && isHTMLNameChar(s[i]) {
+		i++
+	}
+	if i == start || !unicode.IsLetter(rune(s[start])) {
+		return tag, s, false
+	}
+	tag.name = strings.ToLower(s[start:i])
+
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '>':
+			return tag, s[i+1:], true
+		case c == '/':
+			tag.selfClosing = true
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f':
+			i++
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n\f=>/", rune(s[i])) {
+				i++
+			}
+			name := strings.ToLower(s[start:i])
+			value := ""
+			for i < len(s) && strings.ContainsRune(" \t\r\n\f", rune(s[i])) {
+				i++
+			}
+			if i < len(s) && s[i] == '=' {
+				i++
+				for i < len(s) && strings.ContainsRune(" \t\r\n\f", rune(s[i])) {
+					i++
+				}
+				if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+					end := strings.IndexByte(s[i+1:], s[i])
+					if end < 0 {
+						return tag, s, false
+					}
+					value = s[i+1 : i+1+end]
+					i += end + 2
+				} else {
+					start := i
+					for i < len(s) && !strings.ContainsRune(" \t\r\n\f>", rune(s[i])) {
+						i++
+					}
+					value = s[start:i]
+				}
+			}
+			tag.attrs = append(tag.attrs, [2]string{name, html.UnescapeString(value)})
+		}
+	}
+	return tag, s, false
+}
+
+// write writes the tag with the allowed attributes only
+func (tag htmlTag) write(b *strings.Builder) {
+	b.WriteByte('<')
+	if tag.closing {
+		b.WriteByte('/')
+	}
+	b.WriteString(tag.name)
+	if !tag.closing {
+		for _, attr := range tag.attrs {
+			if !isSafeHTMLAttribute(tag.name, attr[0], attr[1]) {
+				continue
+			}
+			b.WriteString(" " + attr[0] + `="` + html.EscapeString(attr[1]) + `"`)
+		}
+	}
+	if tag.selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteByte('>')
+}
+
+// isSafeHTMLAttribute returns true if the attribute is allowed on the tag and its URL or style value is safe
+func isSafeHTMLAttribute(tagName, name, value string) bool {
+	if !globalAttributes[name] && !tagAttributes[tagName][name] {
+		return false
+	}
+	normalized := normalizeAttribute(value)
+	switch {
+	case urlAttributes[name]:
+		return isSafeURL(normalized)
+	case name == "style":
+		return isSafeStyle(normalized)
+	}
+	return true
+}
+
+// normalizeAttribute returns the lower case attribute value without the whitespace and control characters
+// that browsers ignore (e.g. "java\tscript:")
+func normalizeAttribute(value string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+// isSafeURL returns true for the relative URLs and the URLs with the allowedURLSchemes
+func isSafeURL(normalized string) bool {
+	colon := strings.IndexByte(normalized, ':')
+	if colon < 0 || strings.ContainsAny(normalized[:colon], "/?#") {
+		return true
+	}
+	return allowedURLSchemes[normalized[:colon]]
+}
+
+// isSafeStyle returns false for the inline styles that can run scripts or load the URLs with not allowed schemes
+func isSafeStyle(normalized string) bool {
+	// CSS escapes and comments can hide the dangerous values
+	if strings.ContainsAny(normalized, `\<>`) || strings.Contains(normalized, "/*") {
+		return false
+	}
+	for _, s := range []string{"expression(", "behavior:", "-moz-binding", "@import", "javascript:", "vbscript:"} {
+		if strings.Contains(normalized, s) {
+			return false
+		}
+	}
+	for rest := normalized; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return true
+		}
+		rest = rest[i+4:]
+		end := strings.IndexByte(rest, ')')
+		if end < 0 || !isSafeURL(strings.Trim(rest[:end], `"'`)) {
+			return false
+		}
+		rest = rest[end+1:]
+	}
+}
+
+// isHTMLNameChar returns true if the byte can be a part of the HTML tag name
+func isHTMLNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
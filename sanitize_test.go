@@ -0,0 +1,56 @@
+package raweml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"script removed", `<p>Hi</p><script>alert("x<y")</script><p>Bye</p>`, `<p>Hi</p><p>Bye</p>`},
+		{"cid image kept", `<img src="cid:logo" alt="Logo" style="width:10px">`, `<img src="cid:logo" alt="Logo" style="width:10px">`},
+		{"event handler removed", `<a href="https://example.com" onclick="steal()">link</a>`, `<a href="https://example.com">link</a>`},
+		{"javascript URL removed", `<a href="java&#x09;script:alert(1)">link</a>`, `<a>link</a>`},
+		{"iframe removed", `<div><iframe src="https://example.com"></iframe>text</div>`, `<div>text</div>`},
+		{"comment removed", `<!--[if mso]><p>Outlook</p><![endif]--><p>All</p>`, `<p>All</p>`},
+		{"text kept", `1 < 2 & 3 > 2`, `1 &lt; 2 & 3 > 2`},
+		{"data image removed", `<img src="data:image/png;base64,AAAA" />`, `<img />`},
+		{"data link removed", `<a href="data:text/html;base64,AAAA">x</a>`, `<a>x</a>`},
+		{"allowed schemes kept", `<a href="mailto:a@example.com">a</a><a href="/path?x=1">b</a>`, `<a href="mailto:a@example.com">a</a><a href="/path?x=1">b</a>`},
+		{"unknown scheme removed", `<a href="ftp://example.com">x</a>`, `<a>x</a>`},
+		{"svg set removed", `<svg><a><set attributeName="href" to="javascript:alert(1)"/><text y="20">x</text></a></svg><p>ok</p>`, `<p>ok</p>`},
+		{"svg animate removed", `<svg><animate attributeName="href" values="javascript:alert(1)" /></svg>`, ``},
+		{"math removed", `<math><mi xlink:href="javascript:alert(1)">x</mi></math>`, ``},
+		{"style element removed", `<style>@import url(http://evil/);</style><p>ok</p>`, `<p>ok</p>`},
+		{"unknown tag removed", `<marquee onstart="alert(1)">text</marquee>`, `text`},
+		{"unknown attribute removed", `<p formaction="x" data-x="1" align="center">text</p>`, `<p align="center">text</p>`},
+		{"unsafe style removed", `<p style="background:url(javascript:alert(1))">a</p><p style="width:expression(alert(1))">b</p>`, `<p>a</p><p>b</p>`},
+		{"style url kept", `<td style="background:url('https://example.com/bg.png')">a</td>`, `<td style="background:url(&#39;https://example.com/bg.png&#39;)">a</td>`},
+	}
+	for _, tc := range tests {
+		if got := sanitizeHTML(tc.html); got != tc.want {
+			t.Errorf("Invalid sanitized HTML (%s)!\nwant:%s\ngot:%s", tc.name, tc.want, got)
+		}
+	}
+
+	t.Run("Test SanitizeHTML option", func(t *testing.T) {
+		eml := Email{
+			From:         "sender@example.com",
+			Recipients:   NewRecipients("receiver@example.com", "", ""),
+			Subject:      "Sanitize",
+			HTMLBody:     `<p>Hello</p><script>alert(1)</script><img src="cid:logo">`,
+			SanitizeHTML: true,
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); strings.Contains(got, "<script>") || !strings.Contains(got, `<img src="cid:logo">`) {
+			t.Errorf("Invalid sanitized HTML body!\ngot:%s", got)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package raweml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"mime/multipart"
+	"sort"
+	"time"
+)
+
+// Object identifiers used in the S/MIME signature (RFC 5652, RFC 8551)
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidDigestSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidEncryptionRSA          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSignatureECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// pkcs7ContentInfo and the following types are the ASN.1 structures of the PKCS#7 SignedData.
+// The context specific [0] fields are encoded as asn1.RawValue with the explicit class and tag.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SignSMIME signs the email with the certificate and its private key (*rsa.PrivateKey or *ecdsa.PrivateKey).
+// The composed body (text, HTML and attachments) is wrapped in the multipart/signed structure with the detached
+// PKCS#7 signature (application/pkcs7-signature) and saved in the RawBody (see RawBody and RawContentType).
+// The text and HTML bodies of the signed content are base64 encoded regardless of the BodyEncoding.
+// The TextBody, HTMLBody and Attachments are cleared once they are signed in the RawBody,
+// so any change of the body requires setting them again and signing the email again.
+func (email *Email) SignSMIME(cert *x509.Certificate, key crypto.PrivateKey) error {
+	if cert == nil || key == nil {
+		return errors.New("Certificate and private key are required to sign the email.")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("Unsupported private key type %T.", key)
+	}
+
+	// compose the body to sign
+	unsigned := email.Clone()
+	unsigned.RawBody, unsigned.RawContentType = nil, ""
+	// the signed content has to be 7-bit safe (RFC 8551 section 3.1.3) so the relays do not re-encode it and break the signature
+	unsigned.BodyEncoding = "base64"
+	entity, err := unsigned.mimeEntity()
+	if err != nil {
+		return err
+	}
+
+	signature, err := detachedSignature(entity, cert, signer)
+	if err != nil {
+		return err
+	}
+
+	// build the multipart/signed body
+	buf := new(bytes.Buffer)
+	boundary := multipart.NewWriter(buf).Boundary()
+	buf.WriteString("--" + boundary + crlf)
+	buf.Write(entity)
+	buf.WriteString(crlf + "--" + boundary + crlf)
+	buf.WriteString(`Content-Type: application/pkcs7-signature; name="smime.p7s"` + crlf)
+	buf.WriteString("Content-Transfer-Encoding: base64" + crlf)
+	buf.WriteString(`Content-Disposition: attachment; filename="smime.p7s"` + crlf + crlf)
+	buf.WriteString(base64Lines(signature) + crlf)
+	buf.WriteString("--" + boundary + "--" + crlf)
+
+	email.RawBody = buf.Bytes()
+	email.RawContentType = `multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256; boundary="` + boundary + `"`
+	// the bodies and attachments are signed in the RawBody, so they are cleared to not be sent or counted twice
+	email.TextBody, email.HTMLBody, email.htmlBodySource, email.Attachments = "", "", nil, nil
+	return nil
+}
+
+// mimeEntity composes the email and returns its body as the MIME entity (content headers and the body with CRLF line endings)
+func (email Email) mimeEntity() ([]byte, error) {
+	data, err := email.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	header, body, err := splitMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	entity := new(bytes.Buffer)
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition"} {
+		if v := header.Get(key); len(v) > 0 {
+			entity.WriteString(key + ": " + v + crlf)
+		}
+	}
+	entity.WriteString(crlf)
+	entity.WriteString(canonicalLineEndings(string(body)))
+	return entity.Bytes(), nil
+}
+
+// detachedSignature returns the DER encoded PKCS#7 SignedData with the detached SHA-256 signature of the content
+func detachedSignature(content []byte, cert *x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	var signatureAlgorithm asn1.ObjectIdentifier
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = oidEncryptionRSA
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = oidSignatureECDSASHA256
+	default:
+		return nil, fmt.Errorf("Unsupported public key type %T.", signer.Public())
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := signedAttributes(digest[:], time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// the signature is calculated over the DER encoded SET OF attributes
+	attrsDigest := sha256.Sum256(append([]byte{0x31}, attrs[1:]...))
+	signature, err := signer.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			DigestAlgorithm:           sha256Algorithm,
+			AuthenticatedAttributes:   asn1.RawValue{FullBytes: attrs},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithm},
+			EncryptedDigest:           signature,
+		}},
+	}
+	inner, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// signedAttributes returns the DER encoded signed attributes with the [0] IMPLICIT tag
+func signedAttributes(digest []byte, signingTime time.Time) ([]byte, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttributeContentType, oidData},
+		{oidAttributeSigningTime, signingTime},
+		{oidAttributeMessageDigest, digest},
+	}
+	var encoded [][]byte
+	for _, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(pkcs7Attribute{Type: v.oid, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attr)
+	}
+	// DER requires the SET OF elements to be sorted by their encoding
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+}
@@ -0,0 +1,224 @@
+package raweml
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMIME(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "sender@example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Test SignSMIME", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Signed",
+			TextBody:   "Hello\nsigned world",
+		}
+		if err := eml.SignSMIME(cert, key); err != nil {
+			t.Fatalf("Failed to sign the email!\nerror:%s", err)
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+			t.Fatalf("Invalid Content-Type!\nwant:multipart/signed\ngot:%s", msg.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(msg.Body)
+
+		// the signed content is the first part exactly as sent
+		delimiter := "--" + params["boundary"] + "\r\n"
+		start := bytes.Index(body, []byte(delimiter)) + len(delimiter)
+		end := bytes.Index(body[start:], []byte("\r\n"+delimiter))
+		if start < len(delimiter) || end < 0 {
+			t.Fatalf("Signed part not found!\ngot:%s", body)
+		}
+		signed := body[start : start+end]
+		want := "Content-Type: text/plain; charset=us-ascii\r\nContent-Transfer-Encoding: base64\r\n\r\n" + base64.StdEncoding.EncodeToString([]byte("Hello\r\nsigned world"))
+		if !strings.HasPrefix(string(signed), want) {
+			t.Errorf("Invalid signed content!\nwant:%s\ngot:%s", want, signed)
+		}
+
+		// the second part is the detached signature
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		if _, err := mr.NextPart(); err != nil {
+			t.Fatal(err)
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := part.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/pkcs7-signature") {
+			t.Errorf("Invalid signature Content-Type!\nwant:application/pkcs7-signature\ngot:%s", ct)
+		}
+		encoded, _ := io.ReadAll(part)
+		p7s, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var contentInfo pkcs7ContentInfo
+		if _, err := asn1.Unmarshal(p7s, &contentInfo); err != nil {
+			t.Fatal(err)
+		}
+		if !contentInfo.ContentType.Equal(oidSignedData) {
+			t.Errorf("Invalid content type!\nwant:%s\ngot:%s", oidSignedData, contentInfo.ContentType)
+		}
+		var signedData pkcs7SignedData
+		if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+			t.Fatal(err)
+		}
+		if len(signedData.ContentInfo.Content.Bytes) != 0 {
+			t.Errorf("Signature should be detached!")
+		}
+		if !bytes.Equal(signedData.Certificates.Bytes, cert.Raw) {
+			t.Errorf("Signer certificate is missing!")
+		}
+		if len(signedData.SignerInfos) != 1 {
+			t.Fatalf("Invalid number of signers!\nwant:1\ngot:%d", len(signedData.SignerInfos))
+		}
+		signer := signedData.SignerInfos[0]
+		if signer.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			t.Errorf("Invalid serial number!\nwant:%s\ngot:%s", cert.SerialNumber, signer.IssuerAndSerialNumber.SerialNumber)
+		}
+
+		// the message digest attribute matches the signed content
+		digest := sha256.Sum256(signed)
+		found := false
+		rest := signer.AuthenticatedAttributes.Bytes
+		for len(rest) > 0 {
+			var attr pkcs7Attribute
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				t.Fatal(err)
+			}
+			if attr.Type.Equal(oidAttributeMessageDigest) {
+				var value []byte
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+					t.Fatal(err)
+				}
+				found = bytes.Equal(value, digest[:])
+			}
+		}
+		if !found {
+			t.Errorf("Message digest does not match the signed content!")
+		}
+
+		// the signature is calculated over the signed attributes
+		attrs := append([]byte{0x31}, signer.AuthenticatedAttributes.FullBytes[1:]...)
+		if err := cert.CheckSignature(x509.ECDSAWithSHA256, attrs, signer.EncryptedDigest); err != nil {
+			t.Errorf("Invalid signature!\nerror:%s", err)
+		}
+	})
+	t.Run("Test SignSMIME without key", func(t *testing.T) {
+		eml := Email{TextBody: "Hello"}
+		if err := eml.SignSMIME(cert, nil); err == nil {
+			t.Errorf("Signing without the private key should fail!")
+		}
+	})
+	t.Run("Test SignSMIME with non-ASCII body", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Signed",
+			TextBody:   "Здраво свете",
+			HTMLBody:   "<p>Здраво свете</p>",
+		}
+		if err := eml.SignSMIME(cert, key); err != nil {
+			t.Fatalf("Failed to sign the email!\nerror:%s", err)
+		}
+		if !isASCII(string(eml.RawBody)) || strings.Contains(string(eml.RawBody), "8bit") {
+			t.Errorf("Signed content should be 7-bit safe!\ngot:%s", eml.RawBody)
+		}
+		_, params, err := mime.ParseMediaType(eml.RawContentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := multipart.NewReader(bytes.NewReader(eml.RawBody), params["boundary"]).NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, params, err = mime.ParseMediaType(signed.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := multipart.NewReader(signed, params["boundary"]).NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cte := part.Header.Get("Content-Transfer-Encoding"); cte != "base64" {
+			t.Errorf("Invalid Content-Transfer-Encoding!\nwant:base64\ngot:%s", cte)
+		}
+		encoded, _ := io.ReadAll(part)
+		decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Здраво свете"; string(decoded) != want {
+			t.Errorf("Invalid text body!\nwant:%s\ngot:%s", want, decoded)
+		}
+	})
+	t.Run("Test SignSMIME with large attachment", func(t *testing.T) {
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "Signed",
+			TextBody:   "Hello",
+		}
+		// 4.2MB attachment is 5.6MB base64 encoded, so it fits the SES limit only when it is counted once
+		eml.AttachBytes("data.bin", bytes.Repeat([]byte{0xAB}, 4200*1024), "application/octet-stream")
+		if err := eml.SignSMIME(cert, key); err != nil {
+			t.Fatalf("Failed to sign the email!\nerror:%s", err)
+		}
+		if len(eml.Attachments) != 0 || len(eml.TextBody) != 0 {
+			t.Errorf("Signed attachments and body should be cleared!\ngot:%d attachment(s), text body %q", len(eml.Attachments), eml.TextBody)
+		}
+		input, err := eml.GetSendRawEmailInput()
+		if err != nil {
+			t.Fatalf("Signed email should pass the SES size check!\nerror:%s", err)
+		}
+		if n := strings.Count(string(input.RawMessage.Data), `filename="data.bin"`); n != 1 {
+			t.Errorf("Attachment should be sent once!\nwant:1\ngot:%d", n)
+		}
+	})
+}
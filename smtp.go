@@ -0,0 +1,88 @@
+package raweml
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SendSMTP sends the email to the SMTP server at addr (e.g. "smtp.example.com:587") using the optional auth.
+// The envelope sender is the EnvelopeFrom or the first From address and the envelope recipients are all To, Cc and Bcc recipients.
+func (email Email) SendSMTP(addr string, auth smtp.Auth) error {
+	return email.SendVia(context.Background(), SMTPTransport{Addr: addr, Auth: auth})
+}
+
+// sendSMTPSession sends the raw email over the connection to the SMTP server at addr the same way as smtp.SendMail
+// (STARTTLS when the server supports it and AUTH when the auth is set)
+func sendSMTPSession(conn net.Conn, addr string, auth smtp.Auth, from string, to []string, raw []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("SMTP server doesn't support AUTH.")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// envelopeSender returns the bare email address of the EnvelopeFrom or the first From address
+func (email Email) envelopeSender() (string, error) {
+	if len(email.EnvelopeFrom) > 0 {
+		return bareAddress(email.EnvelopeFrom), nil
+	}
+	source, err := email.GetSourceChecked()
+	if err != nil {
+		return "", err
+	}
+	if source == nil {
+		return "", errors.New("Missing From address.")
+	}
+	return bareAddress(*source), nil
+}
+
+// bareAddress returns the email address without the display name (e.g. "John <john@example.com>" -> "john@example.com")
+func bareAddress(address string) string {
+	if a, err := mail.ParseAddress(address); err == nil {
+		return a.Address
+	}
+	return address
+}
@@ -0,0 +1,158 @@
+package raweml
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMTP(t *testing.T) {
+	t.Run("Test sending email via SMTP", func(t *testing.T) {
+		server := newMockSMTP(t)
+		eml := Email{
+			From:       "Support <support@example.com>",
+			Recipients: NewRecipients("customer@example.com", "", "Hidden <hidden@example.com>"),
+			Subject:    "SMTP",
+			TextBody:   "Hello",
+		}
+		if err := eml.SendSMTP(server.addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		<-server.done
+
+		if want := "support@example.com"; server.from != want {
+			t.Errorf("Invalid envelope sender!\nwant:%s\ngot:%s", want, server.from)
+		}
+		if want := "customer@example.com,hidden@example.com"; strings.Join(server.to, ",") != want {
+			t.Errorf("Invalid envelope recipients!\nwant:%s\ngot:%s", want, server.to)
+		}
+		want, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ReplaceAll(string(want), "\r\n", "\n") != server.data {
+			t.Errorf("Invalid DATA!\nwant:%s\ngot:%s", want, server.data)
+		}
+	})
+	t.Run("Test cancelling SMTP send", func(t *testing.T) {
+		// the server accepts the connection but never greets the client
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go func() {
+			conn, err := l.Accept()
+			if err == nil {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}
+		}()
+
+		eml := Email{
+			From:       "support@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "SMTP",
+			TextBody:   "Hello",
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err = eml.SendVia(ctx, SMTPTransport{Addr: l.Addr().String()})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the context error!\nwant:%s\ngot:%v", context.DeadlineExceeded, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Send should stop when the context is done!\ngot:%s", elapsed)
+		}
+	})
+	t.Run("Test SendVia doesn't change the email headers", func(t *testing.T) {
+		server := newMockSMTP(t)
+		eml := Email{
+			From:       "support@example.com",
+			Recipients: NewRecipients("customer@example.com", "", ""),
+			Subject:    "SMTP",
+			TextBody:   "Hello",
+		}
+		eml.SetHeader("X-Custom", "value")
+		if err := eml.SendSMTP(server.addr, nil); err != nil {
+			t.Fatal(err)
+		}
+		<-server.done
+		if len(eml.Headers) != 1 {
+			t.Errorf("Generated headers should not be added to the email!\nwant:%s\ngot:%v", "X-Custom", eml.Headers)
+		}
+	})
+}
+
+// helping functions -----------------------
+
+// mockSMTP is an in-process SMTP server that accepts a single email
+type mockSMTP struct {
+	addr string
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newMockSMTP(t *testing.T) *mockSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	m := &mockSMTP{addr: l.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := textproto.NewReader(bufio.NewReader(conn))
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				m.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				data, err := io.ReadAll(r.DotReader())
+				if err != nil {
+					return
+				}
+				m.data = string(data)
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return m
+}
+
+// / helping functions -----------------------
@@ -5,14 +5,28 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	// "github.com/pborman/uuid"
 )
 
+// maxChildBlockFineDiff is the time difference (in FILETIME units) that doesn't fit in the child block with TimeFlag = 0 (2^(31+18))
+const maxChildBlockFineDiff = 1 << 49
+
+// MaxChildBlocks is the maximum number of child blocks accepted when parsing the Thread-Index
+const MaxChildBlocks = 500
+
+// MaxOutlookTopicLength is the maximum number of characters of the Thread-Topic returned by Thread.OutlookTopic
+const MaxOutlookTopicLength = 255
+
+// ErrInvalidThreadIndex is returned when the Thread-Index value cannot be parsed
+var ErrInvalidThreadIndex = errors.New("invalid Thread-Index")
+
 // Thread represents an email thread (conversation group)
 type Thread struct {
 	DateUnixNano int64        // Thread Date in Unix Nanoseconds
@@ -24,11 +38,11 @@ type Thread struct {
 // ChildBlock represents a sub thread of the email thread
 //
 // - TimeFlag: 1 bit
-//		0 when TimeDiff < 0.02s && TimeDiff > 2 years;
-//		1 when TimeDiff < 1s && TimeDiff > 56 years)
+//		0 when TimeDiff < ~1.78 years (0.026s resolution);
+//		1 when TimeDiff >= ~1.78 years (0.84s resolution, up to ~57 years)
 // - TimeDifference: time difference between the child block create time and the time in the header block expressed in FILETIME units
 // 		if TimeFlag = 0 : discard high 15 bits and low 18 bits
-// 		if TimeFlag = 1 : discard high 10 bits and low 32 bits
+// 		if TimeFlag = 1 : discard high 10 bits and low 23 bits
 // - RandomNum: random number gernerated by calling GetTickCount()
 // - SequenceCount: default set to 0 (Four bits containing a sequence count that is taken from part of the random number.)
 type ChildBlock struct {
@@ -40,7 +54,23 @@ type ChildBlock struct {
 
 // NewThread creates a new Thread struct based on the provided `topic` argument
 func NewThread(topic string) Thread {
-	guid := uuid.NewSHA1(nameSpaceAppID, []byte(topic))
+	return NewThreadInNamespace(nameSpaceAppID, topic)
+}
+
+// NewThreadForSubject creates a new Thread struct for the email subject.
+// The subject is normalized first (see NormalizeSubject) so the replies and forwards are in the same thread as the original email.
+func NewThreadForSubject(subject string) Thread {
+	return NewThread(NormalizeSubject(subject))
+}
+
+// NewThreadInNamespace creates a new Thread struct with the GUID derived from the `namespace` and `topic` arguments.
+// Use it to keep the same topics of different applications (e.g. tenants) in separate threads.
+// A nil namespace (uuid.Nil) falls back to the default namespace used by NewThread.
+func NewThreadInNamespace(namespace uuid.UUID, topic string) Thread {
+	if namespace == uuid.Nil {
+		namespace = nameSpaceAppID
+	}
+	guid := uuid.NewSHA1(namespace, []byte(topic))
 	return Thread{
 		time.Now().UTC().UnixNano(),
 		guid,
@@ -49,6 +79,25 @@ func NewThread(topic string) Thread {
 	}
 }
 
+// NormalizeSubject returns the subject without the reply and forward prefixes (e.g. "RE: FW: Hello" -> "Hello")
+func NormalizeSubject(subject string) string {
+	s := strings.TrimSpace(subject)
+	for {
+		lower := strings.ToLower(s)
+		trimmed := false
+		for _, prefix := range []string{"re:", "fw:", "fwd:"} {
+			if strings.HasPrefix(lower, prefix) {
+				s = strings.TrimSpace(s[len(prefix):])
+				trimmed = true
+				break
+			}
+		}
+		if !trimmed {
+			return s
+		}
+	}
+}
+
 // NewEmailThreadFromParams creates a new Thread struct from arguments
 func NewEmailThreadFromParams(dateUnixNanoSec int64, guid uuid.UUID, topic string, childBlocks []ChildBlock) (r Thread) {
 	return Thread{
@@ -70,13 +119,16 @@ func ParseEmailThread(idx string, topic string) (r Thread, err error) {
 	// -------------------------------------------------------------------------------------------------
 
 	if len(idx) < 22 {
-		return r, errors.New("Inavlid Thread-Index. Expected minimum 22 bytes.")
+		return r, fmt.Errorf("%w %q: expected minimum 22 bytes", ErrInvalidThreadIndex, idx)
 	}
 
 	// decode Base64
 	bytes, errD := base64.StdEncoding.DecodeString(idx)
 	if errD != nil {
-		return r, errD
+		return r, fmt.Errorf("%w %q: %v", ErrInvalidThreadIndex, idx, errD)
+	}
+	if len(bytes) < 22 {
+		return r, fmt.Errorf("%w %q: expected minimum 22 bytes, got %d", ErrInvalidThreadIndex, idx, len(bytes))
 	}
 
 	// get TimeStamp (first 6 bytes)
@@ -87,20 +139,27 @@ func ParseEmailThread(idx string, topic string) (r Thread, err error) {
 	uxNs := timeStampToUnix(binary.BigEndian.Uint64(bTS[:]))
 
 	// Unix Time in nanoseconds
-	threadTimeUnixNano := time.Unix(0, int64(uxNs)).UTC().UnixNano()
+	// NOTE: the header stores only the high 48 bits of the FILETIME so the parsed date has ~6.5ms (2^16 FILETIME units) precision
+	threadTimeUnixNano := int64(uxNs)
 
 	// GUID portion
 	threadGUID, errG := uuid.FromBytes(bytes[6:22])
 	if errG != nil {
-		return r, errG
+		return r, fmt.Errorf("%w %q: %v", ErrInvalidThreadIndex, idx, errG)
 	}
 
 	// child blocks
+	if n := (len(bytes) - 22) / 5; n > MaxChildBlocks {
+		return r, fmt.Errorf("%w %q: %d child blocks exceed the limit of %d", ErrInvalidThreadIndex, idx, n, MaxChildBlocks)
+	}
 	var childBlocks []ChildBlock
-	for i := 22; i < len(bytes) && i < (22+500*5); i += 5 {
+	for i := 22; i < len(bytes); i += 5 {
+		if i+5 > len(bytes) {
+			return r, fmt.Errorf("%w %q: truncated child block at byte %d", ErrInvalidThreadIndex, idx, i)
+		}
 		block, err := ParseChildBlock(string(bytes[i : i+5]))
 		if err != nil {
-			return r, err
+			return r, fmt.Errorf("%w %q: %v", ErrInvalidThreadIndex, idx, err)
 		}
 		childBlocks = append(childBlocks, block)
 	}
@@ -120,6 +179,47 @@ func (thread *Thread) AddChildBlock() {
 	thread.ChildBlocks = append(thread.ChildBlocks, NewChildBlock(deltaTime.Nanoseconds()))
 }
 
+// AddChildBlockWithParams adds a child block with the time difference from the thread date (DateUnixNano)
+// and the explicit random number and sequence count (4 bits each) so the exact Thread-Index can be reproduced.
+func (thread *Thread) AddChildBlockWithParams(delta time.Duration, random, sequence byte) {
+	block := NewChildBlock(delta.Nanoseconds())
+	block.RandomNum = random & 0x0F
+	block.SequenceCount = sequence & 0x0F
+	thread.ChildBlocks = append(thread.ChildBlocks, block)
+}
+
+// AddReply adds a child block for the reply sent at the specified time.
+// The child block time difference is measured from the thread date (DateUnixNano) the same way as in AddChildBlock.
+// Replies sent before the thread date get zero time difference.
+func (thread *Thread) AddReply(at time.Time) {
+	delta := at.UnixNano() - thread.DateUnixNano
+	if delta < 0 {
+		delta = 0
+	}
+	thread.ChildBlocks = append(thread.ChildBlocks, NewChildBlock(delta))
+}
+
+// Time returns the thread date (header block time) in UTC.
+// The date of a parsed thread has ~6.5ms precision because the Thread-Index stores only the high 48 bits of the FILETIME.
+func (thread Thread) Time() time.Time {
+	return time.Unix(0, thread.DateUnixNano).UTC()
+}
+
+// ChildCount returns the number of child blocks (replies/forwards) in the thread
+func (thread Thread) ChildCount() int {
+	return len(thread.ChildBlocks)
+}
+
+// Timestamps returns the approximate time of each child block.
+// Child block time difference is measured from the thread date (see AddChildBlock and AddReply).
+func (thread Thread) Timestamps() []time.Time {
+	var r []time.Time
+	for _, block := range thread.ChildBlocks {
+		r = append(r, time.Unix(0, thread.DateUnixNano+block.TimeDifference).UTC())
+	}
+	return r
+}
+
 // String returns thread data as Base64 encoded string
 func (thread Thread) String() string {
 	return string(thread.Bytes())
@@ -127,6 +227,16 @@ func (thread Thread) String() string {
 
 // Bytes returns thread bytes data encoded in Base64
 func (thread Thread) Bytes() (r []byte) {
+	return []byte(thread.Base64())
+}
+
+// Base64 returns the Thread-Index value (Base64 encoded RawBytes)
+func (thread Thread) Base64() string {
+	return base64.StdEncoding.EncodeToString(thread.RawBytes())
+}
+
+// RawBytes returns the Thread-Index bytes before Base64 encoding (22 bytes header + 5 bytes per child block)
+func (thread Thread) RawBytes() []byte {
 
 	// get Unix nanoseconds
 	tn := thread.DateUnixNano
@@ -137,15 +247,11 @@ func (thread Thread) Bytes() (r []byte) {
 
 	// compose Thread Index
 	bufIdx := new(bytes.Buffer)
-	encoder := base64.NewEncoder(base64.StdEncoding, bufIdx)
-	defer encoder.Close()
-	encoder.Write(tsBytes[:6])                     // 6  - TIME_STAMP
-	encoder.Write(thread.GUIDBytes())              // 16 - GUID
+	bufIdx.Write(tsBytes[:6])                      // 6  - TIME_STAMP
+	bufIdx.Write(thread.GUIDBytes())               // 16 - GUID
 	for i := 0; i < len(thread.ChildBlocks); i++ { // 5  - per Child block
-		encoder.Write(thread.ChildBlocks[i].Bytes())
+		bufIdx.Write(thread.ChildBlocks[i].Bytes())
 	}
-
-	encoder.Close()
 	return bufIdx.Bytes()
 }
 
@@ -163,11 +269,38 @@ func (thread Thread) Index() string {
 	return thread.String()
 }
 
-// Reference returns a hashed version of the Thread GUID that is created based on nameSpaceAppID and Topic
+// Reference returns a hashed version of the Thread GUID that is created based on the namespace and Topic
 func (thread *Thread) Reference() string {
 	return hexToBase64(thread.GUIDBytes())
 }
 
+// ReferenceForTopic returns the reference (see Thread.Reference()) of the thread with the provided topic without creating the Thread.
+// It works only for the threads in the default namespace (see NewThread), use ReferenceForTopicInNamespace for the others.
+func ReferenceForTopic(topic string) string {
+	return ReferenceForTopicInNamespace(nameSpaceAppID, topic)
+}
+
+// ReferenceForTopicInNamespace returns the reference of the thread with the provided namespace and topic (see NewThreadInNamespace).
+// A nil namespace (uuid.Nil) falls back to the default namespace.
+func ReferenceForTopicInNamespace(namespace uuid.UUID, topic string) string {
+	if namespace == uuid.Nil {
+		namespace = nameSpaceAppID
+	}
+	guid := uuid.NewSHA1(namespace, []byte(topic))
+	return hexToBase64(guid[:])
+}
+
+// MatchThreadByReference returns true if the reference (see Thread.Reference()) belongs to the thread with the provided topic.
+// It works only for the threads in the default namespace (see NewThread), use MatchThreadByReferenceInNamespace for the others.
+func MatchThreadByReference(reference string, topic string) bool {
+	return MatchThreadByReferenceInNamespace(reference, nameSpaceAppID, topic)
+}
+
+// MatchThreadByReferenceInNamespace returns true if the reference belongs to the thread with the provided namespace and topic
+func MatchThreadByReferenceInNamespace(reference string, namespace uuid.UUID, topic string) bool {
+	return strings.TrimSpace(reference) == ReferenceForTopicInNamespace(namespace, topic)
+}
+
 // GetGUID returns thread GUID
 func (thread Thread) GetGUID() uuid.UUID {
 	return thread.guid
@@ -178,13 +311,22 @@ func (thread Thread) GetTopic() string {
 	return thread.topic
 }
 
+// OutlookTopic returns the Thread-Topic value Outlook uses for grouping the conversation:
+// the topic without the reply and forward prefixes (see NormalizeSubject), with the whitespace collapsed
+// and capped at MaxOutlookTopicLength characters.
+func (thread Thread) OutlookTopic() string {
+	topic := []rune(strings.Join(strings.Fields(NormalizeSubject(thread.topic)), " "))
+	if len(topic) > MaxOutlookTopicLength {
+		topic = topic[:MaxOutlookTopicLength]
+	}
+	return strings.TrimSpace(string(topic))
+}
+
 // NewChildBlock creates a child header block
 func NewChildBlock(deltaTimeUxNs int64) (r ChildBlock) {
 	// child block is composed of 5 bytes total as follows:
 	// 1 bit 	- One  bit containing a code representing the difference between the current time and the time stored in the header block.
-	//				This bit will be: 0 if the difference is less than .02 second and greater than two years and
-	//								  1 if the difference is less than one second and greater than 56 years.
-	// 				   default value: 0 although MS doesn't specify what value should be set between 1s and 2y
+	// 				   i.e. precision of .02 second for differences up to two years or precision of one second for differences up to 56 years
 	// 31 bits 	- containing the difference between the current time and the time in the header block expressed in FILETIME units.
 	//			  This part of the child block is produced using one of two strategies, depending on the value of the first bit.
 	//			  * If this bit is zero, ScCreateConversationIndex discards the high 15 bits and the low 18 bits.
@@ -194,14 +336,10 @@ func NewChildBlock(deltaTimeUxNs int64) (r ChildBlock) {
 	// -------------------------------------------------------------------------------------------------
 	// ref: https://docs.microsoft.com/en-us/office/client-developer/outlook/mapi/tracking-conversations
 	// -------------------------------------------------------------------------------------------------
-	timeFlag := false
-	deltaDuration := time.Duration(deltaTimeUxNs) * time.Nanosecond
-	deltaYears := deltaDuration.Hours() / 24 / 365
-	if deltaDuration.Seconds() <= 0.02 || deltaYears > 2 {
-		timeFlag = false
-	} else if deltaDuration.Seconds() <= 1 || deltaYears > 56 {
-		timeFlag = true
-	}
+	// The flag selects the resolution of the time difference:
+	//	0 - low 18 bits are discarded (~0.026s resolution) and the remaining 31 bits cover up to 2^49 FILETIME units (~1.78 years)
+	//	1 - low 23 bits are discarded (~0.84s resolution) and the remaining 31 bits cover up to 2^54 FILETIME units (~57 years)
+	timeFlag := deltaTimeUxNs/100 >= maxChildBlockFineDiff
 
 	// random num (last 1 Byte)
 	rand.Seed(time.Now().UnixNano())
@@ -220,8 +358,8 @@ func NewChildBlock(deltaTimeUxNs int64) (r ChildBlock) {
 
 // ParseChildBlock converts string to a ChildBlock struct
 func ParseChildBlock(blockString string) (block ChildBlock, err error) {
-	if len(blockString) < 0 || len(blockString) > 5 {
-		return ChildBlock{}, errors.New("Block string is too short/long!")
+	if len(blockString) != 5 {
+		return ChildBlock{}, fmt.Errorf("Block string is too short/long! Expected 5 bytes, got %d.", len(blockString))
 	}
 
 	bytes := []byte(blockString)
@@ -263,9 +401,7 @@ func ParseChildBlock(blockString string) (block ChildBlock, err error) {
 // 40 bits: 1 flag, 31 time diff, 4 random, 4 seq
 func (block ChildBlock) Bytes() []byte {
 
-	if block.TimeDifference == 0 {
-		return nil
-	}
+	// NOTE: zero time difference (e.g. instant reply) still produces a valid 5 bytes block
 	cbBytes := []byte{0, 0, 0, 0, 0}
 	const FirstBitUp = uint64(0x80000000)
 
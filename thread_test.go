@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -304,6 +305,287 @@ func TestThread(t *testing.T) {
 			t.Errorf("Invalid bytes conversion!\ngot: %v\nwant: %v", got, want)
 		}
 	})
+	t.Run("Test Thread child blocks timeline", func(t *testing.T) {
+		thread, err := ParseEmailThread("Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA=", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := thread.ChildCount(); got != 2 {
+			t.Fatalf("Invalid child count!\ngot: %v\nwant: %v", got, 2)
+		}
+		base := time.Unix(0, thread.DateUnixNano)
+		want := []time.Duration{
+			22*time.Minute + 53897*time.Millisecond,
+			1*time.Minute + 55868*time.Millisecond,
+		}
+		for i, ts := range thread.Timestamps() {
+			if delta := ts.Sub(base) - want[i]; delta > time.Second || delta < -time.Second {
+				t.Errorf("Invalid child block %v time!\ngot: %v\nwant: %v", i, ts.Sub(base), want[i])
+			}
+		}
+	})
+	t.Run("Test parsing invalid Thread-Index", func(t *testing.T) {
+		valid, _ := base64.StdEncoding.DecodeString("AdWzEsgtBcdhxsJwRHGxWvOvVVjQCw==")
+		tests := map[string]string{
+			"too short":       "AdWzEsgt",
+			"not base64":      "!!AdWzEsgtBcdhxsJwRHGxWvOvVVjQCw",
+			"truncated child": base64.StdEncoding.EncodeToString(append(valid, 0, 0, 1)),
+		}
+		for name, idx := range tests {
+			_, err := ParseEmailThread(idx, "")
+			if !errors.Is(err, ErrInvalidThreadIndex) {
+				t.Errorf("Expected ErrInvalidThreadIndex for %s index!\ngot: %v", name, err)
+			}
+		}
+	})
+	t.Run("Test parsing oversized Thread-Index", func(t *testing.T) {
+		header, _ := base64.StdEncoding.DecodeString("AdWzEsgtBcdhxsJwRHGxWvOvVVjQCw==")
+		block := []byte{0x00, 0x00, 0x02, 0x6A, 0x50}
+
+		idx := header
+		for i := 0; i < MaxChildBlocks; i++ {
+			idx = append(idx, block...)
+		}
+		thread, err := ParseEmailThread(base64.StdEncoding.EncodeToString(idx), "")
+		if err != nil || thread.ChildCount() != MaxChildBlocks {
+			t.Fatalf("Expected %v child blocks!\ngot: %v (%v)", MaxChildBlocks, thread.ChildCount(), err)
+		}
+
+		idx = append(idx, block...)
+		if _, err := ParseEmailThread(base64.StdEncoding.EncodeToString(idx), ""); !errors.Is(err, ErrInvalidThreadIndex) {
+			t.Errorf("Expected ErrInvalidThreadIndex for oversized index!\ngot: %v", err)
+		}
+	})
+	t.Run("Test matching thread by reference", func(t *testing.T) {
+		thread := NewThread("Hello world")
+		if !MatchThreadByReference(thread.Reference(), "Hello world") {
+			t.Errorf("Reference %v does not match its topic!", thread.Reference())
+		}
+		if MatchThreadByReference(thread.Reference(), "Another topic") {
+			t.Errorf("Reference %v should not match another topic!", thread.Reference())
+		}
+
+		tenant := uuid.NewSHA1(uuid.Nil, []byte("tenant-a"))
+		thread = NewThreadInNamespace(tenant, "Hello world")
+		if got, want := ReferenceForTopicInNamespace(tenant, "Hello world"), thread.Reference(); got != want {
+			t.Errorf("Invalid reference in namespace!\ngot: %s\nwant: %s", got, want)
+		}
+		if !MatchThreadByReferenceInNamespace(thread.Reference(), tenant, "Hello world") {
+			t.Errorf("Reference %v does not match its namespace and topic!", thread.Reference())
+		}
+		if MatchThreadByReference(thread.Reference(), "Hello world") {
+			t.Errorf("Reference %v should not match the default namespace!", thread.Reference())
+		}
+	})
+	t.Run("Test Thread Base64 and RawBytes", func(t *testing.T) {
+		thread, err := ParseEmailThread("Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA=", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := thread.Base64(); got != "Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA=" {
+			t.Errorf("Invalid Base64!\ngot: %s\nwant: %s", got, "Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA=")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(thread.Base64())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw := thread.RawBytes(); !bytes.Equal(decoded, raw) || len(raw) != 22+2*5 {
+			t.Errorf("Base64 does not decode to RawBytes!\ngot: %X\nwant: %X", decoded, raw)
+		}
+	})
+	t.Run("Test adding replies", func(t *testing.T) {
+		const idx = "Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA="
+		parsed, err := ParseEmailThread(idx, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread := NewEmailThreadFromParams(parsed.DateUnixNano, parsed.GetGUID(), "", nil)
+		base := time.Unix(0, thread.DateUnixNano)
+		thread.AddReply(base.Add(1373896704000))
+		thread.AddReply(base.Add(115867648000))
+
+		// random numbers are not deterministic
+		thread.ChildBlocks[0].RandomNum = 3
+		thread.ChildBlocks[1].RandomNum = 6
+		if got := thread.String(); got != idx {
+			t.Errorf("Invalid Thread-Index!\ngot: %s\nwant: %s", got, idx)
+		}
+	})
+	t.Run("Test replies round-trip through Timestamps", func(t *testing.T) {
+		thread := NewThread("Hello world")
+		base := time.Unix(0, thread.DateUnixNano)
+		replies := []time.Time{base.Add(3 * time.Minute), base.Add(2 * time.Hour), base.Add(30 * 24 * time.Hour)}
+		for _, at := range replies {
+			thread.AddReply(at)
+		}
+		thread.AddChildBlock()
+
+		parsed, err := ParseEmailThread(thread.String(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamps := parsed.Timestamps()
+		if len(timestamps) != 4 {
+			t.Fatalf("Invalid number of timestamps!\ngot: %v\nwant: %v", len(timestamps), 4)
+		}
+		// the parsed date and the child blocks have limited precision
+		for i, at := range append(replies, time.Now()) {
+			if delta := timestamps[i].Sub(at); delta > time.Second || delta < -time.Second {
+				t.Errorf("Invalid reply %v time!\ngot: %v\nwant: %v", i, timestamps[i], at)
+			}
+		}
+	})
+	t.Run("Test zero time difference child block", func(t *testing.T) {
+		thread := NewThread("Hello world")
+		size := len(thread.RawBytes())
+		thread.ChildBlocks = append(thread.ChildBlocks, ChildBlock{false, 0, 7, 1})
+		if got := len(thread.RawBytes()); got != size+5 {
+			t.Errorf("Invalid Thread-Index size!\ngot: %v\nwant: %v", got, size+5)
+		}
+		parsed, err := ParseEmailThread(thread.String(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg := matchChildBlock(parsed.ChildBlocks[0], thread.ChildBlocks[0]); len(msg) > 0 {
+			t.Error(msg)
+		}
+	})
+	t.Run("Test child block TimeFlag", func(t *testing.T) {
+		const year = 365 * 24 * time.Hour
+		tests := []struct {
+			delta time.Duration
+			flag  bool
+		}{
+			{10 * time.Millisecond, false},
+			{500 * time.Millisecond, false},
+			{10 * time.Minute, false},
+			{1 * year, false},
+			{3 * year, true},
+		}
+		for _, item := range tests {
+			block := NewChildBlock(item.delta.Nanoseconds())
+			if block.TimeFlag != item.flag {
+				t.Errorf("Invalid TimeFlag for %v!\ngot: %v\nwant: %v", item.delta, block.TimeFlag, item.flag)
+			}
+
+			// round-trip loses the discarded low bits only
+			parsed, err := ParseChildBlock(block.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolution := time.Duration(100<<18) * time.Nanosecond
+			if item.flag {
+				resolution = time.Duration(100<<23) * time.Nanosecond
+			}
+			if diff := item.delta - time.Duration(parsed.TimeDifference); parsed.TimeFlag != item.flag || diff < 0 || diff >= resolution {
+				t.Errorf("Invalid round-trip for %v!\ngot: %v (flag %v)\nwant: %v (flag %v)", item.delta, time.Duration(parsed.TimeDifference), parsed.TimeFlag, item.delta, item.flag)
+			}
+		}
+	})
+	t.Run("Test normalizing subject", func(t *testing.T) {
+		for subject, want := range map[string]string{
+			"Hello":              "Hello",
+			"RE: Hello":          "Hello",
+			"re:fw: Fwd:  Hello": "Hello",
+			" RE: RE: Hello ":    "Hello",
+		} {
+			if got := NormalizeSubject(subject); got != want {
+				t.Errorf("Invalid normalized subject for %q!\ngot: %s\nwant: %s", subject, got, want)
+			}
+		}
+	})
+	t.Run("Test thread namespace", func(t *testing.T) {
+		tenantA := uuid.NewSHA1(uuid.Nil, []byte("tenant-a"))
+		tenantB := uuid.NewSHA1(uuid.Nil, []byte("tenant-b"))
+		if NewThreadInNamespace(tenantA, "Hello world").GetGUID() == NewThreadInNamespace(tenantB, "Hello world").GetGUID() {
+			t.Errorf("Same topic in different namespaces should have different GUIDs!")
+		}
+		if got := NewThreadInNamespace(tenantA, "Hello world").GetGUID(); got != NewThreadInNamespace(tenantA, "Hello world").GetGUID() {
+			t.Errorf("Same topic in the same namespace should have the same GUID!")
+		}
+		thread := NewThreadInNamespace(uuid.Nil, "Hello world")
+		if got := thread.Reference(); got != "MbfJRQw5X+qg8GSOJxjM2Q==" {
+			t.Errorf("Default namespace should reproduce the existing reference!\ngot:%s\nwant:%s", got, "MbfJRQw5X+qg8GSOJxjM2Q==")
+		}
+	})
+	t.Run("Test thread for subject", func(t *testing.T) {
+		reply := NewThreadForSubject("RE: Hello")
+		original := NewThreadForSubject("Hello")
+		if reply.GetGUID() != original.GetGUID() {
+			t.Errorf("Reply should be in the original thread!\ngot: %s\nwant: %s", reply.GetGUID(), original.GetGUID())
+		}
+		if got := reply.GetTopic(); got != "Hello" {
+			t.Errorf("Invalid topic!\ngot: %s\nwant: %s", got, "Hello")
+		}
+	})
+	t.Run("Test reference for topic", func(t *testing.T) {
+		for _, topic := range []string{"Hello world", "", "Здраво"} {
+			thread := NewThread(topic)
+			if got, want := ReferenceForTopic(topic), thread.Reference(); got != want {
+				t.Errorf("Invalid reference for %q!\ngot: %s\nwant: %s", topic, got, want)
+			}
+		}
+	})
+	t.Run("Test adding child blocks with params", func(t *testing.T) {
+		const idx = "Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA="
+		thread := NewEmailThreadFromParams(int64(timeStampToUnix(130016196641685504)), parseGUID("d78f0e42-8082-4120-b2f1-d0e3c07ed007"), "", nil)
+		thread.AddChildBlockWithParams(time.Duration(13738967040*100), 3, 0) // 0000CCBA30
+		thread.AddChildBlockWithParams(time.Duration(1158676480*100), 6, 0)  // 0000114460
+		if got := thread.Base64(); got != idx {
+			t.Errorf("Invalid Thread-Index!\ngot: %s\nwant: %s", got, idx)
+		}
+	})
+	t.Run("Test parsing short child block", func(t *testing.T) {
+		for _, block := range []string{"", "\x00\x00\xCC", "\x00\x00\xCC\xBA\x30\x00"} {
+			if _, err := ParseChildBlock(block); err == nil {
+				t.Errorf("Expected error for %d bytes child block!", len(block))
+			}
+		}
+		block, err := ParseChildBlock("\x00\x00\xCC\xBA\x30")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.RandomNum != 3 || block.TimeDifference != 13738967040*100 {
+			t.Errorf("Invalid child block!\ngot: %+v", block)
+		}
+	})
+	t.Run("Test thread time", func(t *testing.T) {
+		thread, err := ParseEmailThread("Ac3pCr/g148OQoCCQSCy8dDjwH7QBwAAzLowAAARRGA=", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := time.Date(2013, time.January, 2, 17, 1, 4, 0, time.UTC)
+		got := thread.Time()
+		if got.Location() != time.UTC {
+			t.Errorf("Thread time should be in UTC!\ngot: %v", got.Location())
+		}
+		if diff := got.Sub(want); diff < -time.Second || diff > time.Second {
+			t.Errorf("Invalid thread time!\ngot: %v\nwant: %v", got, want)
+		}
+	})
+	t.Run("Test Outlook topic", func(t *testing.T) {
+		long := strings.Repeat("Quarterly report ", 20)
+		thread := NewThread("RE: FW:  " + long)
+		want := strings.TrimSpace(long[:MaxOutlookTopicLength])
+		if got := thread.OutlookTopic(); got != want {
+			t.Errorf("Invalid Outlook topic!\ngot: %s\nwant: %s", got, want)
+		}
+
+		eml := Email{
+			From:       "sender@example.com",
+			Recipients: NewRecipients("receiver@example.com", "", ""),
+			Subject:    "RE: Hello",
+			TextBody:   "Hello",
+			Topic:      "RE:  Hello \t world",
+		}
+		data, err := eml.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Thread-Topic: Hello world\r\n"; !strings.Contains(string(data), want) {
+			t.Errorf("Invalid Thread-Topic header!\ngot: %s\nwant: %s", data, want)
+		}
+	})
 }
 
 // helping functions -----------------------
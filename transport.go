@@ -0,0 +1,90 @@
+package raweml
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+// Transport delivers the raw email data to the recipients
+type Transport interface {
+	SendRaw(ctx context.Context, from string, to []string, raw []byte) error
+}
+
+// SESTransport sends the raw email using the AWS SES client.
+// When the `from` address is not blank it is used as the envelope sender (SendRawEmailInput.Source).
+type SESTransport struct {
+	Client sesiface.SESAPI
+}
+
+// SMTPTransport sends the raw email to the SMTP server at Addr (e.g. "smtp.example.com:587") using the optional Auth
+type SMTPTransport struct {
+	Addr string
+	Auth smtp.Auth
+}
+
+// SendRaw sends the raw email using the AWS SES client
+func (t SESTransport) SendRaw(ctx context.Context, from string, to []string, raw []byte) error {
+	input := &ses.SendRawEmailInput{
+		Destinations: aws.StringSlice(to),
+		RawMessage: &ses.RawMessage{
+			Data: raw,
+		},
+	}
+	if len(from) > 0 {
+		input.Source = aws.String(from)
+	}
+	_, err := t.Client.SendRawEmailWithContext(ctx, input)
+	return err
+}
+
+// SendRaw sends the raw email to the SMTP server.
+// The connection is closed and the context error is returned when the context is done before the email is sent.
+func (t SMTPTransport) SendRaw(ctx context.Context, from string, to []string, raw []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return err
+	}
+	// abort the SMTP session when the context is done (cancelled or its deadline exceeded)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if err := sendSMTPSession(conn, t.Addr, t.Auth, from, to, raw); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// SendVia composes the email and sends it using the transport.
+// The envelope sender is the EnvelopeFrom or the first From address and the envelope recipients are all To, Cc and Bcc recipients.
+func (email Email) SendVia(ctx context.Context, t Transport) error {
+	data, err := email.Clone().Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := email.envelopeSender()
+	if err != nil {
+		return err
+	}
+	var to []string
+	for _, r := range toStringArray(email.destinations()) {
+		to = append(to, bareAddress(r))
+	}
+	logf("raweml: sending email to %d recipient(s) via %T", len(to), t)
+	return t.SendRaw(ctx, from, to, data)
+}
@@ -0,0 +1,57 @@
+package raweml
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	eml := Email{
+		From:       "Support <support@example.com>",
+		Recipients: NewRecipients("customer@example.com", "Copy <copy@example.com>", "hidden@example.com"),
+		Subject:    "Transport",
+		TextBody:   "Hello",
+	}
+
+	t.Run("Test sending email via fake transport", func(t *testing.T) {
+		fake := &fakeTransport{}
+		if err := eml.SendVia(context.Background(), fake); err != nil {
+			t.Fatal(err)
+		}
+		if want := "support@example.com"; fake.from != want {
+			t.Errorf("Invalid from!\nwant:%s\ngot:%s", want, fake.from)
+		}
+		if want := "customer@example.com,copy@example.com,hidden@example.com"; strings.Join(fake.to, ",") != want {
+			t.Errorf("Invalid to!\nwant:%s\ngot:%s", want, fake.to)
+		}
+		if want, _ := eml.Bytes(); string(fake.raw) != string(want) {
+			t.Errorf("Invalid raw email!\nwant:%s\ngot:%s", want, fake.raw)
+		}
+	})
+	t.Run("Test sending email via SES transport", func(t *testing.T) {
+		mock := &mockSES{}
+		if err := eml.SendVia(context.Background(), SESTransport{Client: mock}); err != nil {
+			t.Fatal(err)
+		}
+		if len(mock.inputs) != 1 || *mock.inputs[0].Source != "support@example.com" || len(mock.inputs[0].Destinations) != 3 {
+			t.Errorf("Invalid SES input!\ngot:%v", mock.inputs)
+		}
+	})
+}
+
+// helping functions -----------------------
+
+// fakeTransport records the sent email
+type fakeTransport struct {
+	from string
+	to   []string
+	raw  []byte
+}
+
+func (f *fakeTransport) SendRaw(ctx context.Context, from string, to []string, raw []byte) error {
+	f.from, f.to, f.raw = from, to, raw
+	return nil
+}
+
+// / helping functions -----------------------